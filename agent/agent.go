@@ -0,0 +1,523 @@
+// Agent mode of mewld (“mewld agent“), runs clusters on behalf of a coordinator mewld on another host
+package agent
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cheesycod/mewld/config"
+	"github.com/cheesycod/mewld/proc"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	log "github.com/sirupsen/logrus"
+)
+
+// A process started by the agent
+type process struct {
+	cmd    *exec.Cmd
+	output *outputLog
+	done   chan struct{}         // Closed once the process has exited
+	err    *proc.RemoteExitError // Only valid after done is closed
+}
+
+// Number of output lines kept per process until the coordinator fetches them
+const maxOutputLines = 1000
+
+// Output lines of a process, numbered from 0 so the coordinator can fetch the lines it has not seen yet
+type outputLog struct {
+	mu      sync.Mutex
+	lines   []string      // The last maxOutputLines lines
+	first   int           // Number of the first line in lines
+	closed  bool          // Whether or not the process has exited and all of its output has been added
+	updated chan struct{} // Closed (and replaced) whenever a line is added or the log is closed
+}
+
+func newOutputLog() *outputLog {
+	return &outputLog{updated: make(chan struct{})}
+}
+
+func (o *outputLog) add(line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.lines = append(o.lines, line)
+
+	if len(o.lines) > maxOutputLines {
+		o.lines = o.lines[1:]
+		o.first++
+	}
+
+	close(o.updated)
+	o.updated = make(chan struct{})
+}
+
+func (o *outputLog) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.closed = true
+
+	close(o.updated)
+	o.updated = make(chan struct{})
+}
+
+// Returns the lines numbered from “from“ on, the number of the next line, whether or not the log is closed and a
+// channel which is closed once any of these change
+func (o *outputLog) since(from int) ([]string, int, bool, <-chan struct{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	next := o.first + len(o.lines)
+
+	if from < o.first {
+		from = o.first
+	}
+
+	if from > next {
+		from = next
+	}
+
+	return append([]string{}, o.lines[from-o.first:]...), next, o.closed, o.updated
+}
+
+// Time given to processes to exit after SIGTERM when the agent fences itself, before they are sent SIGKILL
+const fenceGracePeriod = 5 * time.Second
+
+type Agent struct {
+	Config *config.Agent
+
+	mu           sync.Mutex
+	processes    map[string]*process // Processes by session ID
+	lastContact  time.Time           // Last successful registration with a leader coordinator
+	fenceTimeout time.Duration       // “agents.timeout“ of the leader coordinator
+}
+
+// Creates an agent from the “agent“ section of the config
+func New(cfg *config.Agent) (*Agent, error) {
+	if cfg.Token == "" {
+		return nil, errors.New("agent.token must be set")
+	}
+
+	if cfg.Coordinator == "" {
+		return nil, errors.New("agent.coordinator must be set")
+	}
+
+	if cfg.URL == "" {
+		return nil, errors.New("agent.url must be set")
+	}
+
+	if cfg.Name == "" {
+		hostname, err := os.Hostname()
+
+		if err != nil {
+			return nil, fmt.Errorf("agent.name is not set and the hostname could not be read: %w", err)
+		}
+
+		cfg.Name = hostname
+	}
+
+	if cfg.Addr == "" {
+		cfg.Addr = "0.0.0.0:8101"
+	}
+
+	return &Agent{
+		Config:       cfg,
+		processes:    map[string]*process{},
+		lastContact:  time.Now(),
+		fenceTimeout: 30 * time.Second,
+	}, nil
+}
+
+// Registers with the coordinator and serves the agent API, blocks until the server fails
+func (a *Agent) Run() error {
+	go a.heartbeat()
+
+	go a.fence()
+
+	srv := http.Server{
+		Addr:    a.Config.Addr,
+		Handler: a.router(),
+	}
+
+	log.Info("Agent ", a.Config.Name, " listening on ", a.Config.Addr)
+
+	return srv.ListenAndServe()
+}
+
+// Registers with the coordinators every 10 seconds, which also serves as a heartbeat
+func (a *Agent) heartbeat() {
+	client := http.Client{Timeout: 10 * time.Second}
+
+	for {
+		body, _ := json.Marshal(proc.Agent{
+			Name:     a.Config.Name,
+			URL:      a.Config.URL,
+			Capacity: a.Config.Capacity,
+		})
+
+		// Every mewld instance is registered with when running several of them (ha)
+		for _, coordinator := range strings.Split(a.Config.Coordinator, ",") {
+			res, err := a.register(&client, strings.TrimSpace(coordinator), body)
+
+			if err != nil {
+				log.Error("Could not register with coordinator ", coordinator, ": ", err)
+				continue
+			}
+
+			if res.Leader {
+				a.mu.Lock()
+				a.lastContact = time.Now()
+				if res.Timeout > 0 {
+					a.fenceTimeout = time.Duration(res.Timeout) * time.Second
+				}
+				a.mu.Unlock()
+			}
+		}
+
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (a *Agent) register(client *http.Client, coordinator string, body []byte) (*proc.AgentRegisterResponse, error) {
+	req, err := http.NewRequest("POST", strings.TrimSuffix(coordinator, "/")+"/agents/register", bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(proc.AgentTokenHeader, a.Config.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("coordinator returned %s", res.Status)
+	}
+
+	var reg proc.AgentRegisterResponse
+
+	err = json.NewDecoder(res.Body).Decode(&reg)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not decode registration response: %w", err)
+	}
+
+	return &reg, nil
+}
+
+// Returns true if the agent has not registered with a leader coordinator within its “agents.timeout“, in which case the
+// coordinator treats the processes of the agent as dead and starts them elsewhere
+func (a *Agent) fenced() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return time.Since(a.lastContact) > a.fenceTimeout
+}
+
+// Stops all processes once the agent is fenced, so shards are never run twice after a network partition
+func (a *Agent) fence() {
+	for {
+		time.Sleep(time.Second)
+
+		if !a.fenced() || !a.running() {
+			continue
+		}
+
+		log.Error("Lost contact with the coordinator, stopping all clusters")
+
+		a.StopAll(fenceGracePeriod)
+	}
+}
+
+// Returns true if any process is still running
+func (a *Agent) running() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, p := range a.processes {
+		select {
+		case <-p.done:
+		default:
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stops all processes, sending SIGKILL to processes which have not exited after gracePeriod
+func (a *Agent) StopAll(gracePeriod time.Duration) {
+	a.mu.Lock()
+	procs := make([]*process, 0, len(a.processes))
+	for _, p := range a.processes {
+		procs = append(procs, p)
+	}
+	a.mu.Unlock()
+
+	var wg sync.WaitGroup
+
+	for _, p := range procs {
+		wg.Add(1)
+		go func(p *process) {
+			defer wg.Done()
+
+			p.cmd.Process.Signal(syscall.SIGTERM)
+
+			select {
+			case <-p.done:
+			case <-time.After(gracePeriod):
+				p.cmd.Process.Kill()
+				<-p.done
+			}
+		}(p)
+	}
+
+	wg.Wait()
+}
+
+func (a *Agent) process(sid string) *process {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.processes[sid]
+}
+
+func (a *Agent) router() http.Handler {
+	r := chi.NewMux()
+
+	r.Use(middleware.Recoverer)
+
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get(proc.AgentTokenHeader)
+
+			if subtle.ConstantTimeCompare([]byte(token), []byte(a.Config.Token)) != 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("invalid token"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	r.Post("/processes", func(w http.ResponseWriter, r *http.Request) {
+		var req proc.AgentStartRequest
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+
+		if err != nil || req.SessionID == "" || len(req.Args) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid start request"))
+			return
+		}
+
+		pid, err := a.start(req)
+
+		if err != nil {
+			log.Error("Could not start cluster ", req.ClusterName, " (", req.ClusterID, "): ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proc.AgentStartResponse{Pid: pid})
+	})
+
+	r.Post("/processes/{sid}/signal", func(w http.ResponseWriter, r *http.Request) {
+		p := a.process(chi.URLParam(r, "sid"))
+
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var req proc.AgentSignalRequest
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid signal request"))
+			return
+		}
+
+		err = p.cmd.Process.Signal(syscall.Signal(req.Signal))
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Get("/processes/{sid}/wait", func(w http.ResponseWriter, r *http.Request) {
+		p := a.process(chi.URLParam(r, "sid"))
+
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var res proc.AgentWaitResponse
+
+		select {
+		case <-p.done:
+			res.Exited = true
+			res.Error = p.err
+		case <-time.After(30 * time.Second):
+		case <-r.Context().Done():
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+
+	r.Get("/processes/{sid}/logs", func(w http.ResponseWriter, r *http.Request) {
+		p := a.process(chi.URLParam(r, "sid"))
+
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+
+		lines, next, closed, updated := p.output.since(from)
+
+		// Long-polls until there is new output, like waiting on the process
+		if len(lines) == 0 && !closed {
+			select {
+			case <-updated:
+			case <-time.After(30 * time.Second):
+			case <-r.Context().Done():
+				return
+			}
+
+			lines, next, closed, _ = p.output.since(from)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proc.AgentLogsResponse{
+			Lines:  lines,
+			Next:   next,
+			Exited: closed,
+		})
+	})
+
+	r.Get("/processes/{sid}/metrics", func(w http.ResponseWriter, r *http.Request) {
+		p := a.process(chi.URLParam(r, "sid"))
+
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		metrics, err := proc.SampleProcess(p.cmd.Process.Pid)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metrics)
+	})
+
+	return r
+}
+
+// Starts a process for the coordinator, returning its PID
+func (a *Agent) start(req proc.AgentStartRequest) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if time.Since(a.lastContact) > a.fenceTimeout {
+		return 0, errors.New("agent has lost contact with the coordinator")
+	}
+
+	if _, ok := a.processes[req.SessionID]; ok {
+		return 0, fmt.Errorf("a process with session ID %s already exists", req.SessionID)
+	}
+
+	prefix := "[" + req.ClusterName + " (" + strconv.Itoa(req.ClusterID) + ")] "
+
+	p := &process{
+		output: newOutputLog(),
+		done:   make(chan struct{}),
+	}
+
+	// Lines are written to the console of the agent and kept for the log buffer of the cluster on the coordinator
+	output := func(out *os.File) *proc.LineWriter {
+		return proc.NewLineWriter(func(line string) {
+			out.Write([]byte(prefix + line + "\n"))
+			p.output.add(line)
+		})
+	}
+
+	stdout, stderr := output(os.Stdout), output(os.Stderr)
+
+	cmd := exec.Command(req.Args[0], req.Args[1:]...)
+	cmd.Dir = req.Dir
+	cmd.Env = append(os.Environ(), req.Env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Start()
+
+	if err != nil {
+		return 0, err
+	}
+
+	log.Info("Started cluster ", req.ClusterName, " (", req.ClusterID, ") with PID ", cmd.Process.Pid)
+
+	p.cmd = cmd
+
+	a.processes[req.SessionID] = p
+
+	go func() {
+		err := cmd.Wait()
+
+		// Output is only split into lines on newlines, so the last line of a cluster would otherwise be lost
+		stdout.Flush()
+		stderr.Flush()
+		p.output.close()
+
+		p.err = proc.NewRemoteExitError(err)
+		close(p.done)
+
+		log.Info("Cluster ", req.ClusterName, " (", req.ClusterID, ") exited: ", err)
+
+		// Keep the exit status around for a while so the coordinator can still wait on the process
+		time.AfterFunc(10*time.Minute, func() {
+			a.mu.Lock()
+			delete(a.processes, req.SessionID)
+			a.mu.Unlock()
+		})
+	}()
+
+	return cmd.Process.Pid, nil
+}
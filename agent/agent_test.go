@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cheesycod/mewld/config"
+	"github.com/cheesycod/mewld/proc"
+	"github.com/cheesycod/mewld/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// A cluster which prints a line and runs until it is sent SIGTERM, it then prints a partial line and exits with code 3
+var fakeCluster = []string{"sh", "-c", "trap 'printf partial; exit 3' TERM; echo started; while :; do sleep 0.1; done"}
+
+func newTestAgent(t *testing.T) (*Agent, *httptest.Server) {
+	t.Helper()
+
+	a, err := New(&config.Agent{
+		Name:        "test",
+		Token:       "secret",
+		Coordinator: "http://127.0.0.1:1",
+		URL:         "http://127.0.0.1:1",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(a.router())
+	t.Cleanup(func() {
+		a.StopAll(time.Second)
+		srv.Close()
+	})
+
+	return a, srv
+}
+
+// Sends a request to the agent, decoding the response into resp if it is not nil and returning the status code
+func request(t *testing.T, srv *httptest.Server, token string, method string, path string, body any, resp any) int {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, srv.URL+path, reqBody)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set(proc.AgentTokenHeader, token)
+
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer res.Body.Close()
+
+	if resp != nil && res.StatusCode < 300 {
+		if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return res.StatusCode
+}
+
+func TestAgentHandlers(t *testing.T) {
+	_, srv := newTestAgent(t)
+
+	start := proc.AgentStartRequest{SessionID: "s1", ClusterID: 0, ClusterName: "test", Args: fakeCluster}
+
+	if code := request(t, srv, "wrong", "POST", "/processes", start, nil); code != http.StatusUnauthorized {
+		t.Errorf("start with a wrong token returned %d", code)
+	}
+
+	if code := request(t, srv, "secret", "POST", "/processes", proc.AgentStartRequest{SessionID: "s1"}, nil); code != http.StatusBadRequest {
+		t.Errorf("start without args returned %d", code)
+	}
+
+	var started proc.AgentStartResponse
+	if code := request(t, srv, "secret", "POST", "/processes", start, &started); code != http.StatusOK || started.Pid == 0 {
+		t.Fatalf("start returned %d with PID %d", code, started.Pid)
+	}
+
+	if code := request(t, srv, "secret", "POST", "/processes", start, nil); code != http.StatusInternalServerError {
+		t.Errorf("starting the same session twice returned %d", code)
+	}
+
+	var logs proc.AgentLogsResponse
+	if code := request(t, srv, "secret", "GET", "/processes/s1/logs?from=0", nil, &logs); code != http.StatusOK {
+		t.Fatalf("logs returned %d", code)
+	}
+
+	// The partial line is only emitted once the process exits
+	if !reflect.DeepEqual(logs.Lines, []string{"started"}) || logs.Next != 1 || logs.Exited {
+		t.Errorf("got logs %+v while running", logs)
+	}
+
+	if code := request(t, srv, "secret", "POST", "/processes/s1/signal", proc.AgentSignalRequest{Signal: int(syscall.SIGTERM)}, nil); code != http.StatusNoContent {
+		t.Errorf("signal returned %d", code)
+	}
+
+	var wait proc.AgentWaitResponse
+	if code := request(t, srv, "secret", "GET", "/processes/s1/wait", nil, &wait); code != http.StatusOK {
+		t.Fatalf("wait returned %d", code)
+	}
+
+	if !wait.Exited || wait.Error == nil || wait.Error.ExitCode != 3 {
+		t.Errorf("got wait response %+v, want exit code 3", wait)
+	}
+
+	if code := request(t, srv, "secret", "GET", "/processes/s1/logs?from=1", nil, &logs); code != http.StatusOK {
+		t.Fatalf("logs returned %d", code)
+	}
+
+	if !reflect.DeepEqual(logs.Lines, []string{"partial"}) || logs.Next != 2 || !logs.Exited {
+		t.Errorf("got logs %+v after exiting", logs)
+	}
+
+	for _, path := range []string{"/processes/s2/wait", "/processes/s2/logs", "/processes/s2/metrics"} {
+		if code := request(t, srv, "secret", "GET", path, nil, nil); code != http.StatusNotFound {
+			t.Errorf("%s of an unknown process returned %d", path, code)
+		}
+	}
+}
+
+func TestOutputLogDropsOldLines(t *testing.T) {
+	o := newOutputLog()
+
+	for n := 0; n < maxOutputLines+5; n++ {
+		o.add("line")
+	}
+
+	lines, next, closed, _ := o.since(0)
+
+	if len(lines) != maxOutputLines || next != maxOutputLines+5 || closed {
+		t.Errorf("got %d lines, next line %d and closed %v", len(lines), next, closed)
+	}
+
+	if lines, _, _, _ := o.since(next + 10); len(lines) != 0 {
+		t.Errorf("got %d lines past the end", len(lines))
+	}
+}
+
+// An ipc backend which drops everything written to it
+type nopIpc struct{}
+
+func (nopIpc) Connect() error                                { return nil }
+func (nopIpc) Disconnect() error                             { return nil }
+func (nopIpc) Read() chan []byte                             { return make(chan []byte) }
+func (nopIpc) Write([]byte) error                            { return nil }
+func (nopIpc) GetKey(key string) ([]byte, error)             { return nil, nil }
+func (nopIpc) StoreKey(key string, value []byte) error       { return nil }
+func (nopIpc) GetKey_Array(key string) ([][]byte, error)     { return nil, nil }
+func (nopIpc) StoreKey_Array(key string, value []byte) error { return nil }
+
+// Waits until the log buffer of an instance holds the given lines
+func waitForLogs(t *testing.T, l *proc.InstanceList, i *proc.Instance, want []string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		got := l.ClusterLogBuffer(i).Last(0)
+
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("got logs %q, want %q", got, want)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestClusterOnAgent(t *testing.T) {
+	a, srv := newTestAgent(t)
+
+	cfg := &config.CoreConfig{
+		PingInterval: 3600,
+		Command:      fakeCluster,
+		// There is no bot to acknowledge prepare_shutdown
+		ShutdownAckTimeout: utils.Pointer(0),
+		Agents: config.Agents{
+			Enabled: true,
+			Token:   "secret",
+		},
+	}
+	cfg.SetDefaults()
+
+	l := &proc.InstanceList{
+		Ctx:        context.Background(),
+		Config:     cfg,
+		LoaderData: &proc.LoaderData{Start: proc.DefaultStart},
+		IPC:        nopIpc{},
+		Dir:        t.TempDir(),
+	}
+
+	clusterMap := proc.GetClusterList(nil, 2, 2)
+	l.SetInstances(clusterMap, []*proc.Instance{proc.NewInstance(clusterMap[0])})
+
+	l.RegisterAgent(proc.Agent{Name: "test", URL: srv.URL, Capacity: 2})
+
+	i := l.List()[0]
+
+	i.AcquireLockAndLock(l, "test")
+	err := l.Start(i)
+	i.Unlock()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info := i.Snapshot(); info.Host != "test" {
+		t.Fatalf("cluster was placed on %q, want the agent", info.Host)
+	}
+
+	if a.process(i.Session()) == nil {
+		t.Fatal("agent is not running the process of the cluster")
+	}
+
+	waitForLogs(t, l, i, []string{"started"})
+
+	i.AcquireLockAndLock(l, "test")
+	l.Stop(i)
+	i.Unlock()
+
+	if state := i.Status(); state != proc.StateStopped {
+		t.Errorf("cluster is %s after stopping it", state)
+	}
+
+	waitForLogs(t, l, i, []string{"started", "partial"})
+}
@@ -1,12 +1,116 @@
 package config
 
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
 type Oauth struct {
 	ClientID     string `yaml:"client_id"`
 	ClientSecret string `yaml:"client_secret" json:"-"`
 	RedirectURL  string `yaml:"redirect_url"`
 }
 
+// Controls how clusters that die unexpectedly are restarted
+type RestartPolicy struct {
+	BaseDelay   *int `yaml:"base_delay"`   // Seconds to wait before the first automatic restart, doubled for every restart in the window (default 3)
+	MaxDelay    *int `yaml:"max_delay"`    // Maximum seconds to wait before an automatic restart (default 300)
+	MaxRestarts *int `yaml:"max_restarts"` // Maximum automatic restarts within the window before a cluster is considered crash looping (default 5)
+	Window      *int `yaml:"window"`       // Window in seconds in which automatic restarts are counted (default 600)
+}
+
+// Controls how the stdout/stderr of clusters is captured
+type ClusterLogs struct {
+	Dir         string `yaml:"dir"`          // Directory to write per-cluster log files to, relative to the bot directory if not absolute. No log files are written if unset
+	Console     *bool  `yaml:"console"`      // Whether or not to mirror cluster output to the console of mewld (default true)
+	MaxSize     *int   `yaml:"max_size"`     // Rotate a log file once it reaches this many megabytes, 0 disables size based rotation (default 100)
+	MaxAge      int    `yaml:"max_age"`      // Rotate a log file once it has been written to for this many hours, 0 disables age based rotation
+	MaxBackups  int    `yaml:"max_backups"`  // Number of rotated log files to keep per cluster, 0 keeps all of them
+	Compress    bool   `yaml:"compress"`     // Whether or not to gzip rotated log files
+	BufferLines *int   `yaml:"buffer_lines"` // Number of recent lines kept in memory per cluster for the web API (default 1000)
+}
+
+// cgroup v2 resource limits for clusters, limits are written as-is to the matching cgroup interface files
+type Cgroup struct {
+	Enabled    bool   `yaml:"enabled"`
+	Root       string `yaml:"root"`        // The cgroup under which every cluster gets its own cgroup (default /sys/fs/cgroup/mewld)
+	MemoryMax  string `yaml:"memory_max"`  // memory.max, such as 2G
+	MemoryHigh string `yaml:"memory_high"` // memory.high, such as 1536M
+	CPUMax     string `yaml:"cpu_max"`     // cpu.max, such as "200000 100000" for 2 CPUs
+	PidsMax    string `yaml:"pids_max"`    // pids.max, such as 512
+}
+
+// Automatic recycling (restarting) of clusters which use too much memory or have been up for too long
+type Recycle struct {
+	MaxRSS        uint64 `yaml:"max_rss"`        // Recycle a cluster once its RSS exceeds this many megabytes, 0 disables
+	MaxUptime     int    `yaml:"max_uptime"`     // Recycle a cluster once it has been up for this many minutes, 0 disables
+	CheckInterval *int   `yaml:"check_interval"` // Seconds between recycle checks (default 60)
+	LaunchTimeout *int   `yaml:"launch_timeout"` // Seconds to wait for a recycled cluster to send launch_next before recycling the next one (default 300)
+}
+
+// An operation which is run by the scheduler on a cron expression
+type ScheduledOperation struct {
+	Name      string `yaml:"name"`
+	Cron      string `yaml:"cron"`       // Standard 5 field cron expression (minute hour day-of-month month day-of-week)
+	Operation string `yaml:"operation"`  // One of rollingrestart, restart or reshard
+	ClusterID *int   `yaml:"cluster_id"` // The cluster to restart, only applicable to restart
+}
+
+// Identify queue server for clusters, compatible with the HTTP protocol of twilight's gateway-queue
+type IdentifyQueue struct {
+	Enabled bool   `yaml:"enabled"`
+	Addr    string `yaml:"addr"` // Address to listen on (default 127.0.0.1:8000), this server is unauthenticated
+}
+
+// High availability of mewld itself, several mewld instances elect a leader using a lease in the ipc backend (redis)
+type HA struct {
+	Enabled  bool   `yaml:"enabled"`
+	NodeID   string `yaml:"node_id"`   // Unique ID of this mewld instance, defaults to hostname-pid
+	LeaseTTL *int   `yaml:"lease_ttl"` // Seconds after which the lease of a dead leader expires (default 15)
+}
+
+// Coordinator side of multi-host clustering, clusters are placed on remote agents (“mewld agent“)
+type Agents struct {
+	Enabled       bool   `yaml:"enabled"`
+	Addr          string `yaml:"addr"`           // Address the agent registration server listens on (default 0.0.0.0:8100)
+	Token         string `yaml:"token"`          // Shared secret used by agents and the coordinator, required
+	LocalCapacity uint64 `yaml:"local_capacity"` // Number of shards which may run on the coordinator itself, 0 runs all clusters on agents
+	MinAgents     int    `yaml:"min_agents"`     // Number of agents to wait for before launching clusters
+	Timeout       *int   `yaml:"timeout"`        // Seconds without a heartbeat after which an agent is considered lost (default 30)
+}
+
+// Agent side of multi-host clustering, used by “mewld agent“
+type Agent struct {
+	Name        string `yaml:"name"`        // Name of the agent, defaults to the hostname
+	Coordinator string `yaml:"coordinator"` // Base URL of the agent registration server of the coordinator, several can be separated by commas (ha)
+	Addr        string `yaml:"addr"`        // Address the agent listens on (default 0.0.0.0:8101)
+	URL         string `yaml:"url"`         // URL the coordinator should use to reach the agent
+	Capacity    uint64 `yaml:"capacity"`    // Number of shards the agent can run
+	Token       string `yaml:"token"`       // Shared secret, must match agents.token of the coordinator
+}
+
+// Controls how rolling restarts are done
+type RollingRestart struct {
+	BatchSize      *int     `yaml:"batch_size"`      // Number of clusters restarted at the same time (default 1)
+	HealthTimeout  *int     `yaml:"health_timeout"`  // Seconds a batch has to become healthy after sending launch_next before the rolling restart is paused (default 300)
+	HealthInterval *int     `yaml:"health_interval"` // Seconds between health checks of a batch (default 10)
+	MaxLatency     *float64 `yaml:"max_latency"`     // Maximum latency (as reported in diag) for a shard to be considered healthy, 0 disables the latency check (default 0)
+	LaunchTimeout  *int     `yaml:"launch_timeout"`  // Seconds every cluster of a batch has to send launch_next (or be skipped by launch_timeout) before the rolling restart is paused (default 900)
+}
+
+// A bot run by the same mewld process as other bots, every setting which is not set here is inherited from the top-level config
+type Bot struct {
+	Name         string `yaml:"name"`          // Name of the bot, its web API is served under /bots/{name}
+	Token        string `yaml:"token"`         // Token of the bot, passed to its clusters as MTOKEN
+	RedisChannel string `yaml:"redis_channel"` // IPC channel of the bot, must differ between bots as IPC keys are namespaced by it
+	Dir          string `yaml:"dir"`           // Directory of the bot, see dir
+}
+
 type CoreConfig struct {
+	Name                         string   `yaml:"name"`  // Name of the bot, its web API is also served under /bots/{name} (default 'default')
 	Token                        string   `yaml:"token"` // Either set token or the MTOKEN env var
 	Dir                          string   `yaml:"dir"`
 	OverrideDir                  string   `yaml:"override_dir"`
@@ -22,13 +126,242 @@ type CoreConfig struct {
 	PingInterval                 int      `yaml:"ping_interval"`
 	ClusterStartNextDelay        *int     `yaml:"cluster_start_next_delay"`
 	PerCluster                   uint64   `yaml:"per_cluster"`
+	ShardStrategy                string   `yaml:"shard_strategy"` // How shards are distributed across clusters, one of 'contiguous' (default), 'round_robin', 'bucket_aligned' or 'weighted'
 	MinimumSafeSessionsRemaining *uint64  `yaml:"minimum_safe_sessions_remaining"`
 	FixedShardCount              uint64   `yaml:"fixed_shard_count"`     // You likely don't want this outside of rare use cases...
 	ExperimentalFeatures         []string `yaml:"experimental_features"` // 'reshard'
 	ReshardAll                   bool     `yaml:"reshard_all"`           // If this is false, then only clusters with differing Shard ID arrays will be resharded, otherwise all clusters will be resharded
 	Proxy                        string   `yaml:"proxy"`                 // If this is set, then all discord api requests will be proxied through this URL
+	ShutdownAckTimeout           *int     `yaml:"shutdown_ack_timeout"`  // Seconds to wait for a cluster to acknowledge prepare_shutdown before sending SIGTERM (default 10)
+	StopGracePeriod              *int     `yaml:"stop_grace_period"`     // Seconds to wait after SIGTERM before a cluster is force-killed with SIGKILL (default 30)
+	MetricsInterval              *int     `yaml:"metrics_interval"`      // Seconds between samples of the resource usage of clusters from /proc, 0 disables sampling (default 30)
+	MetricsHistory               *int     `yaml:"metrics_history"`       // Number of resource usage samples to keep per cluster (default 120)
+	LaunchTimeout                *int     `yaml:"launch_timeout"`        // Seconds a cluster has to send launch_next after being started, 0 disables the timeout (default 600)
+	LaunchTimeoutPolicy          string   `yaml:"launch_timeout_policy"` // What to do with a cluster which did not send launch_next in time, either 'restart' (default) or 'skip'
+	SessionBudgetSyncInterval    *int     `yaml:"budget_sync_interval"`  // Seconds between syncs of the session start budget from Get Gateway Bot (default 300)
 
 	// The command/module to run, only applicable when using DefaultStart (or the mewld executable)
 	Module string `yaml:"module"`
 	Interp string `yaml:"interp"`
+
+	// Templated argv and environment of clusters, see proc.CommandVars. Only applicable when using DefaultStart (or the mewld executable)
+	Command    []string          `yaml:"command"`     // Overrides interp/module and the default argument layout if set
+	ClusterEnv map[string]string `yaml:"cluster_env"` // Extra environment variables passed to clusters
+
+	// Automatic restart policy for clusters that die unexpectedly or fail ping checks
+	RestartPolicy RestartPolicy `yaml:"restart_policy"`
+	Recycle       Recycle       `yaml:"recycle"`
+
+	// Batching and health gating of rolling restarts
+	RollingRestart RollingRestart `yaml:"rolling_restart"`
+
+	// Zero-downtime (blue/green) restarts, clusters are replaced by a standby process instead of being stopped and started
+	ZeroDowntimeRestarts bool `yaml:"zero_downtime_restarts"` // Whether or not restarts and rolling restarts replace clusters
+	StandbyTimeout       *int `yaml:"standby_timeout"`        // Seconds a standby process has to send standby_ready before the replacement is aborted (default 300)
+
+	// Capture of cluster stdout/stderr, only applicable when using DefaultStart (or the mewld executable)
+	ClusterLogs ClusterLogs `yaml:"cluster_logs"`
+
+	// Per-cluster cgroup v2 resource limits, only applicable when using DefaultStart (or the mewld executable)
+	Cgroup Cgroup `yaml:"cgroup"`
+
+	// Identify queue server for clusters
+	IdentifyQueue IdentifyQueue `yaml:"identify_queue"`
+
+	// Adoption of running clusters when mewld is restarted
+	AdoptClusters     bool   `yaml:"adopt_clusters"`      // Leave clusters running when mewld stops (or restartproc is used) and adopt them when it starts again
+	StateFile         string `yaml:"state_file"`          // File the state of clusters is saved to, the ipc key store is used if this is not set
+	StateSaveInterval *int   `yaml:"state_save_interval"` // Seconds between saves of the state of clusters (default 10)
+
+	// Leader election between several mewld instances
+	HA HA `yaml:"ha"`
+
+	// Multi-host clustering, Agents is used by the coordinator and Agent by “mewld agent“
+	Agents Agents `yaml:"agents"`
+	Agent  Agent  `yaml:"agent"`
+
+	// Operations to run on cron expressions
+	Schedules []ScheduledOperation `yaml:"schedules"`
+
+	// Several bots run by one mewld process, see BotConfigs
+	Bots []Bot `yaml:"bots"`
+}
+
+// Returns the config of every bot in “bots“, or just this config if it is not set
+//
+// The config of a bot is a deep copy of this config with the name, token, redis channel and directory of the bot set. The
+// token is also passed to the clusters of the bot as MTOKEN, as they would otherwise inherit the MTOKEN of mewld. The
+// identify queue and agent server of the nth bot (counting from 0) listen on the port of “identify_queue.addr“ and
+// “agents.addr“ plus n, so the servers of different bots do not collide
+func (c *CoreConfig) BotConfigs() ([]*CoreConfig, error) {
+	if c.Name == "" {
+		c.Name = "default"
+	}
+
+	if len(c.Bots) == 0 {
+		return []*CoreConfig{c}, nil
+	}
+
+	configs := make([]*CoreConfig, 0, len(c.Bots))
+	names := map[string]bool{}
+	channels := map[string]bool{}
+
+	for n, b := range c.Bots {
+		switch {
+		case b.Name == "" || strings.Contains(b.Name, "/"):
+			return nil, fmt.Errorf("invalid bot name %q", b.Name)
+		case names[b.Name]:
+			return nil, fmt.Errorf("bot %s is listed more than once", b.Name)
+		case b.Token == "":
+			return nil, fmt.Errorf("bot %s has no token", b.Name)
+		case b.RedisChannel == "":
+			return nil, fmt.Errorf("bot %s has no redis_channel", b.Name)
+		case channels[b.RedisChannel]:
+			return nil, fmt.Errorf("bot %s uses redis_channel %s which is already used by another bot", b.Name, b.RedisChannel)
+		}
+
+		names[b.Name] = true
+		channels[b.RedisChannel] = true
+
+		bc := c.clone()
+		bc.Name = b.Name
+		bc.Token = b.Token
+		bc.RedisChannel = b.RedisChannel
+		bc.Bots = nil
+
+		if b.Dir != "" {
+			bc.Dir = b.Dir
+			bc.OverrideDir = ""
+		}
+
+		// Resources on this host are namespaced by the name of the bot so bots do not overwrite each other
+		if c.StateFile != "" {
+			ext := filepath.Ext(c.StateFile)
+			bc.StateFile = strings.TrimSuffix(c.StateFile, ext) + "-" + b.Name + ext
+		}
+
+		if c.ClusterLogs.Dir != "" {
+			bc.ClusterLogs.Dir = filepath.Join(c.ClusterLogs.Dir, b.Name)
+		}
+
+		bc.Cgroup.Root = filepath.Join(orDefault(c.Cgroup.Root, defaultCgroupRoot), b.Name)
+
+		var err error
+		bc.IdentifyQueue.Addr, err = offsetPort(orDefault(c.IdentifyQueue.Addr, defaultIdentifyQueueAddr), n)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid identify_queue.addr: %w", err)
+		}
+
+		bc.Agents.Addr, err = offsetPort(orDefault(c.Agents.Addr, defaultAgentsAddr), n)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid agents.addr: %w", err)
+		}
+
+		bc.ClusterEnv["MTOKEN"] = b.Token
+
+		configs = append(configs, bc)
+	}
+
+	return configs, nil
+}
+
+// Returns a deep copy of the config, so bots never share settings through pointers, slices or maps
+func (c *CoreConfig) clone() *CoreConfig {
+	cc := *c
+
+	cc.Env = cloneSlice(c.Env)
+	cc.Names = cloneSlice(c.Names)
+	cc.AllowedIDS = cloneSlice(c.AllowedIDS)
+	cc.ExperimentalFeatures = cloneSlice(c.ExperimentalFeatures)
+	cc.Command = cloneSlice(c.Command)
+	cc.Bots = cloneSlice(c.Bots)
+
+	cc.ClusterEnv = map[string]string{}
+	for k, v := range c.ClusterEnv {
+		cc.ClusterEnv[k] = v
+	}
+
+	cc.Schedules = cloneSlice(c.Schedules)
+	for idx := range cc.Schedules {
+		cc.Schedules[idx].ClusterID = clonePtr(c.Schedules[idx].ClusterID)
+	}
+
+	cc.PingTimeout = clonePtr(c.PingTimeout)
+	cc.ClusterStartNextDelay = clonePtr(c.ClusterStartNextDelay)
+	cc.MinimumSafeSessionsRemaining = clonePtr(c.MinimumSafeSessionsRemaining)
+	cc.ShutdownAckTimeout = clonePtr(c.ShutdownAckTimeout)
+	cc.StopGracePeriod = clonePtr(c.StopGracePeriod)
+	cc.MetricsInterval = clonePtr(c.MetricsInterval)
+	cc.MetricsHistory = clonePtr(c.MetricsHistory)
+	cc.LaunchTimeout = clonePtr(c.LaunchTimeout)
+	cc.SessionBudgetSyncInterval = clonePtr(c.SessionBudgetSyncInterval)
+	cc.StandbyTimeout = clonePtr(c.StandbyTimeout)
+	cc.StateSaveInterval = clonePtr(c.StateSaveInterval)
+
+	cc.RestartPolicy.BaseDelay = clonePtr(c.RestartPolicy.BaseDelay)
+	cc.RestartPolicy.MaxDelay = clonePtr(c.RestartPolicy.MaxDelay)
+	cc.RestartPolicy.MaxRestarts = clonePtr(c.RestartPolicy.MaxRestarts)
+	cc.RestartPolicy.Window = clonePtr(c.RestartPolicy.Window)
+
+	cc.Recycle.CheckInterval = clonePtr(c.Recycle.CheckInterval)
+	cc.Recycle.LaunchTimeout = clonePtr(c.Recycle.LaunchTimeout)
+
+	cc.RollingRestart.BatchSize = clonePtr(c.RollingRestart.BatchSize)
+	cc.RollingRestart.HealthTimeout = clonePtr(c.RollingRestart.HealthTimeout)
+	cc.RollingRestart.HealthInterval = clonePtr(c.RollingRestart.HealthInterval)
+	cc.RollingRestart.MaxLatency = clonePtr(c.RollingRestart.MaxLatency)
+	cc.RollingRestart.LaunchTimeout = clonePtr(c.RollingRestart.LaunchTimeout)
+
+	cc.ClusterLogs.Console = clonePtr(c.ClusterLogs.Console)
+	cc.ClusterLogs.MaxSize = clonePtr(c.ClusterLogs.MaxSize)
+	cc.ClusterLogs.BufferLines = clonePtr(c.ClusterLogs.BufferLines)
+
+	cc.HA.LeaseTTL = clonePtr(c.HA.LeaseTTL)
+	cc.Agents.Timeout = clonePtr(c.Agents.Timeout)
+
+	return &cc
+}
+
+func cloneSlice[T any](s []T) []T {
+	if s == nil {
+		return nil
+	}
+
+	return append([]T{}, s...)
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+
+	v := *p
+	return &v
+}
+
+func orDefault(s string, def string) string {
+	if s == "" {
+		return def
+	}
+
+	return s
+}
+
+// Adds n to the port of a host:port address
+func offsetPort(addr string, n int) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return "", err
+	}
+
+	p, err := strconv.Atoi(port)
+
+	if err != nil || p+n > 65535 {
+		return "", fmt.Errorf("invalid port in %s", addr)
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(p+n)), nil
 }
@@ -0,0 +1,72 @@
+package config
+
+import "testing"
+
+func TestBotConfigs(t *testing.T) {
+	c := &CoreConfig{
+		ClusterEnv: map[string]string{"A": "1"},
+		Command:    []string{"./bot"},
+		Agents:     Agents{Addr: "0.0.0.0:9000"},
+		Bots: []Bot{
+			{Name: "a", Token: "ta", RedisChannel: "ca"},
+			{Name: "b", Token: "tb", RedisChannel: "cb"},
+		},
+	}
+
+	bots, err := c.BotConfigs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bots) != 2 {
+		t.Fatalf("got %d bots, want 2", len(bots))
+	}
+
+	a, b := bots[0], bots[1]
+
+	if a.IdentifyQueue.Addr != "127.0.0.1:8000" || b.IdentifyQueue.Addr != "127.0.0.1:8001" {
+		t.Errorf("got identify queue addresses %s and %s", a.IdentifyQueue.Addr, b.IdentifyQueue.Addr)
+	}
+
+	if a.Agents.Addr != "0.0.0.0:9000" || b.Agents.Addr != "0.0.0.0:9001" {
+		t.Errorf("got agent server addresses %s and %s", a.Agents.Addr, b.Agents.Addr)
+	}
+
+	if a.ClusterEnv["MTOKEN"] != "ta" || b.ClusterEnv["MTOKEN"] != "tb" || c.ClusterEnv["MTOKEN"] != "" {
+		t.Errorf("got MTOKEN %q, %q and %q in the top-level config", a.ClusterEnv["MTOKEN"], b.ClusterEnv["MTOKEN"], c.ClusterEnv["MTOKEN"])
+	}
+
+	// Settings of one bot must not leak into the others
+	a.SetDefaults()
+	*a.RollingRestart.BatchSize = 5
+	a.Command[0] = "./other"
+
+	if b.RollingRestart.BatchSize != nil || c.RollingRestart.BatchSize != nil {
+		t.Error("defaults of a bot were set in another config")
+	}
+
+	if b.Command[0] != "./bot" || c.Command[0] != "./bot" {
+		t.Error("command of a bot is shared with another config")
+	}
+}
+
+func TestBotConfigsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		c    CoreConfig
+	}{
+		{"duplicate name", CoreConfig{Bots: []Bot{{Name: "a", Token: "t", RedisChannel: "ca"}, {Name: "a", Token: "t", RedisChannel: "cb"}}}},
+		{"duplicate redis channel", CoreConfig{Bots: []Bot{{Name: "a", Token: "t", RedisChannel: "c"}, {Name: "b", Token: "t", RedisChannel: "c"}}}},
+		{"no token", CoreConfig{Bots: []Bot{{Name: "a", RedisChannel: "c"}}}},
+		{"invalid addr", CoreConfig{IdentifyQueue: IdentifyQueue{Addr: "8000"}, Bots: []Bot{{Name: "a", Token: "t", RedisChannel: "c"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.c.BotConfigs(); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
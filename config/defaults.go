@@ -0,0 +1,156 @@
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+const (
+	defaultCgroupRoot        = "/sys/fs/cgroup/mewld"
+	defaultIdentifyQueueAddr = "127.0.0.1:8000"
+	defaultAgentsAddr        = "0.0.0.0:8100"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// Fills in the default of every unset setting, must be called once after loading the config and before it is used
+//
+// The config is shared by every goroutine of an instance list, which only read it, so defaults can not be set lazily
+func (c *CoreConfig) SetDefaults() {
+	if c.Name == "" {
+		c.Name = "default"
+	}
+
+	if c.PingTimeout == nil {
+		c.PingTimeout = ptr(120)
+	}
+
+	if c.ClusterStartNextDelay == nil {
+		c.ClusterStartNextDelay = ptr(5)
+	}
+
+	if c.MinimumSafeSessionsRemaining == nil {
+		c.MinimumSafeSessionsRemaining = ptr[uint64](5)
+	}
+
+	if c.ShutdownAckTimeout == nil {
+		c.ShutdownAckTimeout = ptr(10)
+	}
+
+	if c.StopGracePeriod == nil {
+		c.StopGracePeriod = ptr(30)
+	}
+
+	if c.MetricsInterval == nil {
+		c.MetricsInterval = ptr(30)
+	}
+
+	if c.MetricsHistory == nil {
+		c.MetricsHistory = ptr(120)
+	}
+
+	if c.LaunchTimeout == nil {
+		c.LaunchTimeout = ptr(600)
+	}
+
+	if c.SessionBudgetSyncInterval == nil {
+		c.SessionBudgetSyncInterval = ptr(300)
+	}
+
+	if c.StandbyTimeout == nil {
+		c.StandbyTimeout = ptr(300)
+	}
+
+	if c.StateSaveInterval == nil {
+		c.StateSaveInterval = ptr(10)
+	}
+
+	rp := &c.RestartPolicy
+
+	if rp.BaseDelay == nil {
+		rp.BaseDelay = ptr(3)
+	}
+
+	if rp.MaxDelay == nil {
+		rp.MaxDelay = ptr(300)
+	}
+
+	if rp.MaxRestarts == nil {
+		rp.MaxRestarts = ptr(5)
+	}
+
+	if rp.Window == nil {
+		rp.Window = ptr(600)
+	}
+
+	if c.Recycle.CheckInterval == nil {
+		c.Recycle.CheckInterval = ptr(60)
+	}
+
+	if c.Recycle.LaunchTimeout == nil {
+		c.Recycle.LaunchTimeout = ptr(300)
+	}
+
+	rc := &c.RollingRestart
+
+	if rc.BatchSize == nil || *rc.BatchSize < 1 {
+		rc.BatchSize = ptr(1)
+	}
+
+	if rc.HealthTimeout == nil {
+		rc.HealthTimeout = ptr(300)
+	}
+
+	if rc.HealthInterval == nil {
+		rc.HealthInterval = ptr(10)
+	}
+
+	if rc.MaxLatency == nil {
+		rc.MaxLatency = ptr[float64](0)
+	}
+
+	if rc.LaunchTimeout == nil {
+		rc.LaunchTimeout = ptr(900)
+	}
+
+	cl := &c.ClusterLogs
+
+	if cl.Console == nil {
+		cl.Console = ptr(true)
+	}
+
+	if cl.MaxSize == nil {
+		cl.MaxSize = ptr(100)
+	}
+
+	if cl.BufferLines == nil {
+		cl.BufferLines = ptr(1000)
+	}
+
+	if c.Cgroup.Root == "" {
+		c.Cgroup.Root = defaultCgroupRoot
+	}
+
+	if c.IdentifyQueue.Addr == "" {
+		c.IdentifyQueue.Addr = defaultIdentifyQueueAddr
+	}
+
+	if c.HA.NodeID == "" {
+		hostname, _ := os.Hostname()
+		c.HA.NodeID = hostname + "-" + strconv.Itoa(os.Getpid())
+	}
+
+	if c.HA.LeaseTTL == nil {
+		c.HA.LeaseTTL = ptr(15)
+	}
+
+	if c.Agents.Addr == "" {
+		c.Agents.Addr = defaultAgentsAddr
+	}
+
+	if c.Agents.Timeout == nil {
+		c.Agents.Timeout = ptr(30)
+	}
+}
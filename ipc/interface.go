@@ -1,5 +1,7 @@
 package ipc
 
+import "time"
+
 // A simple abstraction for a mewld ipc connection
 type Ipc interface {
 	Connect() error
@@ -11,3 +13,15 @@ type Ipc interface {
 	GetKey_Array(key string) ([][]byte, error)
 	StoreKey_Array(key string, value []byte) error
 }
+
+// Optional interface for ipc connections clusters can connect to, used to tell clusters how to reach mewld
+type Describer interface {
+	Backend() string // Name of the ipc backend, such as redis or unixsocket
+	Address() string // Address clusters should connect to
+}
+
+// Optional interface for ipc backends supporting leases, used for leader election
+type Leaser interface {
+	AcquireLease(key string, holder string, ttl time.Duration) (bool, error) // Acquires or renews a lease, returns false if another holder has it
+	ReleaseLease(key string, holder string) error                            // Releases a lease if it is held by holder
+}
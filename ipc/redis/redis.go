@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -12,6 +13,7 @@ type RedisHandler struct {
 	Ctx          context.Context `json:"-"`
 	Redis        *redis.Client   `json:"-"`
 	RedisChannel string          `json:"-"`
+	RedisURL     string          `json:"-"`
 
 	cancel  context.CancelFunc `json:"-"`
 	msgChan chan []byte        `json:"-"`
@@ -45,11 +47,20 @@ func NewWithRedis(ctx context.Context, redisURL string, redisChannel string) (*R
 		Ctx:          ctx,
 		Redis:        rdb,
 		RedisChannel: redisChannel,
+		RedisURL:     redisURL,
 		cancel:       cancel,
 		msgChan:      make(chan []byte, 100),
 	}, nil
 }
 
+func (r *RedisHandler) Backend() string {
+	return "redis"
+}
+
+func (r *RedisHandler) Address() string {
+	return r.RedisURL
+}
+
 func (r *RedisHandler) Connect() error {
 	// Start pubsub
 	pubsub := r.Redis.Subscribe(r.Ctx, r.RedisChannel)
@@ -122,3 +133,41 @@ func (r *RedisHandler) GetKey_Array(key string) ([][]byte, error) {
 func (r *RedisHandler) StoreKey_Array(key string, value []byte) error {
 	return r.Redis.RPush(r.Ctx, r.RedisChannel+"/"+key, value).Err()
 }
+
+// Renews a lease if it is held by the given holder
+var renewLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Deletes a lease if it is held by the given holder
+var releaseLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (r *RedisHandler) AcquireLease(key string, holder string, ttl time.Duration) (bool, error) {
+	key = r.RedisChannel + "/" + key
+
+	ok, err := r.Redis.SetNX(r.Ctx, key, holder, ttl).Result()
+
+	if err != nil || ok {
+		return ok, err
+	}
+
+	renewed, err := renewLease.Run(r.Ctx, r.Redis, []string{key}, holder, ttl.Milliseconds()).Int()
+
+	if err != nil {
+		return false, err
+	}
+
+	return renewed == 1, nil
+}
+
+func (r *RedisHandler) ReleaseLease(key string, holder string) error {
+	return releaseLease.Run(r.Ctx, r.Redis, []string{r.RedisChannel + "/" + key}, holder).Err()
+}
@@ -44,6 +44,14 @@ func NewWithUnixSocket(ctx context.Context, filename string) (*UnixSocketHandler
 	}, nil
 }
 
+func (r *UnixSocketHandler) Backend() string {
+	return "unixsocket"
+}
+
+func (r *UnixSocketHandler) Address() string {
+	return r.Filename
+}
+
 func (r *UnixSocketHandler) Connect() error {
 	// Create unix socket file
 	var err error
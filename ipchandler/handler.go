@@ -70,6 +70,11 @@ func (r *IpcHandler) Start(il *proc.InstanceList) {
 			continue
 		}
 
+		// Only the leader acts on commands when running several mewld instances
+		if !il.IsLeader() {
+			continue
+		}
+
 		switch cmd.Action {
 		case "diag":
 			if str, ok := cmd.Output.(string); ok {
@@ -84,17 +89,27 @@ func (r *IpcHandler) Start(il *proc.InstanceList) {
 					continue
 				}
 
-				proc.DiagChannel <- diagPayload
+				il.DeliverDiag(diagPayload)
 			} else {
 				log.Error("Diagnostic message parse error: ", cmd.Output)
 			}
 		case "action_logs":
 			go il.ActionLog(cmd.Data)
+		case "prepare_shutdown_ack":
+			clusterId, ok := cmd.Args["id"].(float64)
+
+			if !ok {
+				log.Error("Could not get cluster id from args: ", cmd.Args["id"])
+				continue
+			}
+
+			il.AcknowledgeShutdown(int(clusterId))
 		case "restartproc":
 			log.Info("Restarting process: ", cmd.CommandId)
 			il.Acknowledge(cmd.CommandId)
-			il.KillAll()
-			os.Exit(1)
+
+			// Stopping clusters waits on IPC messages (prepare_shutdown_ack), so this must not block the handler
+			go il.Exit(1)
 		case "launch_next":
 			// Get cluster id from args
 			typeOfId := reflect.TypeOf(cmd.Args["id"])
@@ -107,7 +122,7 @@ func (r *IpcHandler) Start(il *proc.InstanceList) {
 				log.Error("Could not get cluster id from args: ", cmd.Args["id"])
 
 				// Continue if its roll restarting, we cant continue
-				if il.RollRestarting {
+				if il.IsRollRestarting() {
 					continue
 				}
 			} else {
@@ -118,12 +133,11 @@ func (r *IpcHandler) Start(il *proc.InstanceList) {
 					continue
 				}
 
-				instance.LaunchedFully = true
+				il.MarkLaunched(instance)
 			}
 
-			if il.RollRestarting {
-				// Push to proc.RollRestartChannel
-				proc.RollRestartChannel <- int(clusterId)
+			if il.IsRollRestarting() {
+				il.NotifyRollRestart(int(clusterId))
 				continue
 			}
 
@@ -131,17 +145,31 @@ func (r *IpcHandler) Start(il *proc.InstanceList) {
 		case "rollingrestart":
 			go func() {
 				il.Acknowledge(cmd.CommandId)
-				il.RollingRestart()
+
+				if err := il.RollingRestart(); err != nil {
+					log.Error("Could not start rolling restart: ", err)
+				}
+			}()
+		case "rollingrestart_resume":
+			go func() {
+				il.Acknowledge(cmd.CommandId)
+
+				if err := il.ResumeRollingRestart(); err != nil {
+					log.Error("Could not resume rolling restart: ", err)
+				}
 			}()
 		case "statuses":
 			payload := map[string]status{}
 
-			for _, i := range il.Instances {
+			for _, i := range il.List() {
+				info := i.Snapshot()
+
 				statusStruct := status{
-					Active:    i.Active,
+					Active:    i.Running(),
+					Status:    string(info.State),
 					Name:      il.Cluster(i).Name,
-					StartedAt: i.StartedAt.Unix(),
-					ShardList: i.Shards,
+					StartedAt: info.StartedAt.Unix(),
+					ShardList: info.Shards,
 				}
 				payload[strconv.Itoa(i.ClusterID)] = statusStruct
 			}
@@ -150,8 +178,11 @@ func (r *IpcHandler) Start(il *proc.InstanceList) {
 		case "shutdown":
 			log.Warn("Got request to shutdown (hopefully you have systemctl)")
 			il.Acknowledge(cmd.CommandId)
-			il.KillAll()
-			syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+
+			go func() {
+				il.KillAll()
+				syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+			}()
 		case "stop":
 			typeOfId := reflect.TypeOf(cmd.Args["id"])
 
@@ -164,21 +195,28 @@ func (r *IpcHandler) Start(il *proc.InstanceList) {
 				continue
 			}
 
-			for _, i := range il.Instances {
+			for _, i := range il.List() {
 				if i.ClusterID == int(clusterId) {
-					if !i.Active {
-						log.Error("Instance is not active")
+					if !i.Running() {
+						log.Error("Instance is not running (", i.Status(), ")")
 						continue
 					}
 
 					il.Acknowledge(cmd.CommandId)
 
-					err := il.Stop(i)
+					go func(i *proc.Instance) {
+						if err := i.Lock(il, "Redis.stop"); err != nil {
+							return
+						}
 
-					if err != proc.StopCodeNormal {
-						log.Error("Could not stop instance: ", err)
-						continue
-					}
+						defer i.Unlock()
+
+						err := il.Stop(i)
+
+						if err != proc.StopCodeNormal {
+							log.Error("Could not stop instance: ", err)
+						}
+					}(i)
 
 					break
 				}
@@ -195,12 +233,21 @@ func (r *IpcHandler) Start(il *proc.InstanceList) {
 				continue
 			}
 
-			for _, i := range il.Instances {
+			for _, i := range il.List() {
 				if i.ClusterID == int(clusterId) {
 					il.Acknowledge(cmd.CommandId)
 
+					if err := i.Lock(il, "Redis.start"); err != nil {
+						il.SendMessage(cmd.CommandId, "instance is locked", "bot", "")
+						break
+					}
+
+					il.ClearCrashLoop(i, "start")
+
 					err := il.Start(i)
 
+					i.Unlock()
+
 					if err != nil {
 						log.Error("Could not start instance: ", err)
 						go il.ActionLog(map[string]any{
@@ -227,65 +274,151 @@ func (r *IpcHandler) Start(il *proc.InstanceList) {
 				continue
 			}
 
-			for _, i := range il.Instances {
+			for _, i := range il.List() {
 				if i.ClusterID == int(clusterId) {
-					if !i.Active {
-						log.Error("Instance is not active")
+					if !i.Running() {
+						log.Error("Instance is not running (", i.Status(), ")")
 						continue
 					}
 
 					il.Acknowledge(cmd.CommandId)
 
-					i.Lock(il, "Redis.restart", false)
+					go func(i *proc.Instance) {
+						il.ClearCrashLoop(i, "restart")
 
-					err := il.Stop(i)
-
-					if err == proc.StopCodeNormal {
-						err := il.Start(i)
+						err := il.Restart(i, "Redis.restart")
 
 						if err != nil {
-							log.Error("Could not start instance: ", err)
+							log.Error("Could not restart instance: ", err)
 							go il.ActionLog(map[string]any{
 								"event": "cluster_restart_failed",
 								"via":   "restart",
 							})
-							continue
 						}
-					} else {
-						log.Error("Could not stop instance: ", err)
-					}
-
-					i.Unlock()
+					}(i)
 
 					break
 				}
 			}
-		case "reshard":
+		case "replace":
+			typeOfId := reflect.TypeOf(cmd.Args["id"])
+
+			log.Info("Got replace command for cluster ", cmd.Args["id"], " (", typeOfId, ")")
+
+			clusterId, ok := cmd.Args["id"].(float64)
+
+			if !ok {
+				log.Error("Could not get cluster id from args: ", cmd.Args["id"])
+				continue
+			}
+
+			i := il.InstanceByID(int(clusterId))
+
+			if i == nil {
+				log.Error("Could not find instance with id: ", clusterId)
+				continue
+			}
+
+			if !i.Running() {
+				log.Error("Instance is not running (", i.Status(), ")")
+				continue
+			}
+
 			il.Acknowledge(cmd.CommandId)
 
-			il.ActionLog(map[string]any{
-				"event":     "reshard_begin",
-				"subsystem": "redis",
-			})
+			// Replacing waits on IPC messages (standby_ready, prepare_shutdown_ack), so this must not block the handler
+			go func() {
+				if err := i.Lock(il, "Redis.replace"); err != nil {
+					return
+				}
 
-			err := il.Reshard()
+				defer i.Unlock()
 
-			if err != nil {
-				il.ActionLog(map[string]any{
-					"event":     "reshard_failed",
-					"error":     err.Error(),
-					"subsystem": "redis",
-				})
+				err := il.Replace(i)
+
+				if err != nil {
+					log.Error("Could not replace instance: ", err)
+				}
+			}()
+		case "cordon", "uncordon":
+			clusterId, ok := cmd.Args["id"].(float64)
+
+			if !ok {
+				log.Error("Could not get cluster id from args: ", cmd.Args["id"])
+				continue
+			}
+
+			i := il.InstanceByID(int(clusterId))
+
+			if i == nil {
+				log.Error("Could not find instance with id: ", clusterId)
+				continue
+			}
+
+			var err error
+			if cmd.Action == "cordon" {
+				err = il.Cordon(i)
 			} else {
+				err = il.Uncordon(i)
+			}
+
+			if err != nil {
+				log.Error("Could not ", cmd.Action, " instance: ", err)
+				il.SendMessage(cmd.CommandId, err.Error(), "bot", "")
+				continue
+			}
+
+			il.Acknowledge(cmd.CommandId)
+		case "standby_ready":
+			clusterId, ok := cmd.Args["id"].(float64)
+
+			if !ok {
+				log.Error("Could not get cluster id from args: ", cmd.Args["id"])
+				continue
+			}
+
+			sid, _ := cmd.Args["session_id"].(string)
+
+			il.StandbyReady(int(clusterId), sid)
+		case "reshard":
+			il.Acknowledge(cmd.CommandId)
+
+			go func() {
 				il.ActionLog(map[string]any{
-					"event":     "reshard_success",
+					"event":     "reshard_begin",
 					"subsystem": "redis",
 				})
-			}
+
+				err := il.Reshard()
+
+				if err != nil {
+					il.ActionLog(map[string]any{
+						"event":     "reshard_failed",
+						"error":     err.Error(),
+						"subsystem": "redis",
+					})
+				} else {
+					il.ActionLog(map[string]any{
+						"event":     "reshard_success",
+						"subsystem": "redis",
+					})
+				}
+			}()
+		case "reshard_plan":
+			go func() {
+				plan, err := il.PlanReshard()
+
+				if err != nil {
+					il.SendMessage(cmd.CommandId, err.Error(), "bot", "")
+					return
+				}
+
+				il.SendMessage(cmd.CommandId, plan, "bot", "")
+			}()
 		case "num_processes":
 			payload := numproc{
-				Clusters: len(il.Instances),
-				Shards:   il.ShardCount,
+				Clusters: len(il.List()),
+				Shards:   il.TotalShards(),
 			}
 
 			il.SendMessage(cmd.CommandId, payload, "bot", "")
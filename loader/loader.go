@@ -10,6 +10,7 @@ import (
 	"github.com/cheesycod/mewld/ipc"
 	"github.com/cheesycod/mewld/ipchandler"
 	"github.com/cheesycod/mewld/proc"
+	"github.com/cheesycod/mewld/scheduler"
 	"github.com/cheesycod/mewld/utils"
 	"github.com/cheesycod/mewld/web"
 
@@ -17,7 +18,111 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// A bot which has been loaded but whose clusters have not been started yet
+type bot struct {
+	config *config.CoreConfig
+	il     *proc.InstanceList
+	sched  *scheduler.Scheduler
+	gb     *proc.GatewayBot
+}
+
 func Load(config *config.CoreConfig, loaderData *proc.LoaderData, ipc ipc.Ipc) (*proc.InstanceList, error) {
+	b, err := prepare(config, loaderData, ipc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !config.UseCustomWebUI {
+		go serveWeb(b.webData())
+	}
+
+	err = launch(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b.il, nil
+}
+
+// Loads several bots (see config.BotConfigs) using an IPC backend per bot created by newIpc, serving the web API of all bots from one webserver
+//
+// The web API of the first bot is also served at the root of the webserver, which handles logins for all bots
+func LoadBots(configs []*config.CoreConfig, loaderData *proc.LoaderData, newIpc func(c *config.CoreConfig) (ipc.Ipc, error)) ([]*proc.InstanceList, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no bots to load")
+	}
+
+	bots := make([]*bot, 0, len(configs))
+	lists := make([]*proc.InstanceList, 0, len(configs))
+
+	for _, c := range configs {
+		log.Info("Loading bot ", c.Name)
+
+		bIpc, err := newIpc(c)
+
+		if err != nil {
+			return nil, fmt.Errorf("error creating ipc for bot %s: %w", c.Name, err)
+		}
+
+		b, err := prepare(c, loaderData, bIpc)
+
+		if err != nil {
+			return nil, fmt.Errorf("error loading bot %s: %w", c.Name, err)
+		}
+
+		bots = append(bots, b)
+		lists = append(lists, b.il)
+	}
+
+	for _, b := range bots {
+		b.il.SetBots(lists)
+	}
+
+	if !configs[0].UseCustomWebUI {
+		webData := bots[0].webData()
+
+		for _, b := range bots[1:] {
+			webData.Bots = append(webData.Bots, b.webData())
+		}
+
+		go serveWeb(webData)
+	}
+
+	for _, b := range bots {
+		err := launch(b)
+
+		if err != nil {
+			return nil, fmt.Errorf("error launching bot %s: %w", b.config.Name, err)
+		}
+	}
+
+	return lists, nil
+}
+
+func (b *bot) webData() web.WebData {
+	return web.WebData{
+		Name:         b.config.Name,
+		InstanceList: b.il,
+		Scheduler:    b.sched,
+	}
+}
+
+func serveWeb(webData web.WebData) {
+	srv := web.StartWebserver(webData)
+
+	err := srv.ListenAndServe()
+
+	if err != nil {
+		log.Error("Error starting webserver: ", err)
+	}
+}
+
+// Creates the instance list of a bot and starts its IPC handler and background checks
+func prepare(config *config.CoreConfig, loaderData *proc.LoaderData, ipc ipc.Ipc) (*bot, error) {
+	config.SetDefaults()
+
 	var err error
 	if len(config.Env) > 0 {
 		err = godotenv.Load(config.Env...)
@@ -29,12 +134,6 @@ func Load(config *config.CoreConfig, loaderData *proc.LoaderData, ipc ipc.Ipc) (
 		log.Println("Env files loaded")
 	}
 
-	if config.MinimumSafeSessionsRemaining == nil {
-		config.MinimumSafeSessionsRemaining = utils.Pointer[uint64](5)
-	}
-
-	mssr := *config.MinimumSafeSessionsRemaining
-
 	gb, err := proc.GetGatewayBot(config)
 
 	if err != nil {
@@ -47,12 +146,6 @@ func Load(config *config.CoreConfig, loaderData *proc.LoaderData, ipc ipc.Ipc) (
 
 	log.Println("Using shard count:", gb.Shards)
 
-	var perCluster uint64 = config.PerCluster
-
-	log.Println("Cluster names:", config.Names)
-
-	clusterMap := proc.GetClusterList(config.Names, gb.Shards, perCluster)
-
 	dir, err := utils.ConfigGetDirectory(config)
 
 	if err != nil {
@@ -70,40 +163,95 @@ func Load(config *config.CoreConfig, loaderData *proc.LoaderData, ipc ipc.Ipc) (
 		Config:     config,
 		LoaderData: loaderData,
 		Dir:        dir,
-		Map:        clusterMap,
-		Instances:  []*proc.Instance{},
 		ShardCount: gb.Shards,
 		GatewayBot: *gb,
 		IPC:        ipc,
 	}
 
+	log.Println("Cluster names:", config.Names)
+
+	clusterMap, err := il.BuildClusterMap(gb)
+
+	if err != nil {
+		return nil, fmt.Errorf("error building cluster map: %w", err)
+	}
+
 	// Start the IPC handler
 	ipch := ipchandler.IpcHandler{
 		Ctx:          il.Ctx,
 		InstanceList: il,
 	}
 
+	il.SyncSessionBudget(gb)
+
 	go ipch.Start(il)
 
+	go il.SessionBudgetCheck()
+
+	go il.RecycleCheck()
+
+	if config.AdoptClusters || config.HA.Enabled {
+		go il.StateSaveCheck()
+	}
+
+	instances := make([]*proc.Instance, 0, len(clusterMap))
+
 	for _, cMap := range clusterMap {
 		log.Info("Cluster ", cMap.Name, "("+strconv.Itoa(cMap.ID)+"): ", utils.ToPyListUInt64(cMap.Shards))
-		il.Instances = append(il.Instances, &proc.Instance{
-			SessionID: utils.RandomString(16),
-			ClusterID: cMap.ID,
-			Shards:    cMap.Shards,
-		})
+		instances = append(instances, proc.NewInstance(cMap))
 	}
 
-	if !config.UseCustomWebUI {
+	il.SetInstances(clusterMap, instances)
+
+	sched, err := scheduler.NewFromConfig(il, config.Schedules)
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating scheduler: %w", err)
+	}
+
+	return &bot{
+		config: config,
+		il:     il,
+		sched:  sched,
+		gb:     gb,
+	}, nil
+}
+
+// Starts the servers of a bot and then its clusters
+func launch(b *bot) error {
+	config, il, gb := b.config, b.il, b.gb
+
+	mssr := *config.MinimumSafeSessionsRemaining
+
+	if config.IdentifyQueue.Enabled {
 		go func() {
-			srv := web.StartWebserver(web.WebData{
-				InstanceList: il,
-			})
+			srv := web.StartIdentifyQueue(il, config.IdentifyQueue.Addr)
 
 			err := srv.ListenAndServe()
 
 			if err != nil {
-				log.Error("Error starting webserver: ", err)
+				log.Error("Error starting identify queue: ", err)
+			}
+		}()
+	}
+
+	if config.Agents.Enabled {
+		if config.Agents.Token == "" {
+			return fmt.Errorf("agents.token must be set when agents are enabled")
+		}
+
+		// Agents start clusters without cgroups, so limits and OOM detection would silently only apply to some clusters
+		if config.Cgroup.Enabled {
+			return fmt.Errorf("cgroup cannot be used along with agents")
+		}
+
+		go func() {
+			srv := web.StartAgentServer(il, config.Agents.Addr)
+
+			err := srv.ListenAndServe()
+
+			if err != nil {
+				log.Error("Error starting agent server: ", err)
 			}
 		}()
 	}
@@ -113,14 +261,36 @@ func Load(config *config.CoreConfig, loaderData *proc.LoaderData, ipc ipc.Ipc) (
 		time.Sleep(time.Millisecond * time.Duration(gb.SessionStartLimit.ResetAfter))
 	}
 
-	// We now start the first cluster, this cluster will then alert us over redis when to start cluster 2 (todo: timeout?)
+	// We now start the first clusters (one per set of independent identify buckets), these clusters will then alert us over redis when to start the next ones
+	// (or are restarted/skipped if they do not do so within launch_timeout)
 	go func() {
-		err = il.Start(il.Instances[0])
+		if config.Agents.Enabled && config.Agents.MinAgents > 0 {
+			il.WaitForAgents(config.Agents.MinAgents)
+		}
 
-		if err != nil {
-			log.Error("Error starting cluster 1: ", err)
+		if config.HA.Enabled {
+			// Clusters are started (or adopted) once this instance becomes the leader
+			err := il.RunElection()
+
+			if err != nil {
+				log.Fatal("Error running leader election: ", err)
+			}
+
+			return
 		}
+
+		if config.AdoptClusters {
+			if state := il.LoadState(); state != nil {
+				log.Info("Adopting clusters left running at ", state.SavedAt)
+
+				adopted := il.Adopt(state)
+
+				log.Info("Adopted ", adopted, " clusters")
+			}
+		}
+
+		il.StartNext()
 	}()
 
-	return il, nil
+	return nil
 }
@@ -6,8 +6,11 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/cheesycod/mewld/agent"
 	"github.com/cheesycod/mewld/config"
+	"github.com/cheesycod/mewld/ipc"
 	"github.com/cheesycod/mewld/ipc/redis"
 	"github.com/cheesycod/mewld/loader"
 	"github.com/cheesycod/mewld/utils"
@@ -59,13 +62,18 @@ func main() {
 		os.Setenv("MTOKEN", config.Token)
 	}
 
-	redisIpc, err := redis.NewWithRedis(context.Background(), config.Redis, config.RedisChannel)
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		runAgent(&config)
+		return
+	}
+
+	bots, err := config.BotConfigs()
 
 	if err != nil {
-		log.Fatal("Error creating redis IPC: ", err)
+		log.Fatal("Invalid bots: ", err)
 	}
 
-	il, err := loader.Load(&config, nil, redisIpc)
+	ils, err := loader.LoadBots(bots, nil, newRedisIpc)
 
 	if err != nil {
 		log.Fatal("Error loading instances: ", err)
@@ -80,8 +88,41 @@ func main() {
 
 	log.Info("Received signal: ", sig)
 
-	il.KillAll()
+	// Stops (or leaves running, see Exit) the clusters of every bot
+	ils[0].Exit(0)
+}
+
+// Creates the redis IPC of a bot
+func newRedisIpc(c *config.CoreConfig) (ipc.Ipc, error) {
+	return redis.NewWithRedis(context.Background(), c.Redis, c.RedisChannel)
+}
+
+// Runs mewld in agent mode (“mewld agent“), starting clusters on behalf of a coordinator
+func runAgent(config *config.CoreConfig) {
+	a, err := agent.New(&config.Agent)
+
+	if err != nil {
+		log.Fatal("Error creating agent: ", err)
+	}
+
+	go func() {
+		err := a.Run()
+
+		if err != nil {
+			log.Fatal("Error running agent: ", err)
+		}
+	}()
+
+	// Wait here until we get a signal
+	sigs := make(chan os.Signal, 1)
+
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-sigs
+
+	log.Info("Received signal: ", sig)
+
+	a.StopAll(30 * time.Second)
 
-	// Exit
 	os.Exit(0)
 }
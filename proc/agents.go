@@ -0,0 +1,430 @@
+package proc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A remote host running clusters for the coordinator, see “mewld agent“
+type Agent struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`      // Base URL of the HTTP API of the agent
+	Capacity uint64    `json:"capacity"` // Number of shards the agent can run
+	LastSeen time.Time `json:"last_seen"`
+	Alive    bool      `json:"alive"` // Whether or not the agent has sent a heartbeat within “agents.timeout“, set by Agents
+}
+
+// Request sent to an agent to start the process of a cluster
+type AgentStartRequest struct {
+	SessionID   string   `json:"session_id"`
+	ClusterID   int      `json:"cluster_id"`
+	ClusterName string   `json:"cluster_name"`
+	Args        []string `json:"args"`
+	Env         []string `json:"env"` // Added to the environment of the agent
+	Dir         string   `json:"dir"`
+}
+
+type AgentStartResponse struct {
+	Pid int `json:"pid"`
+}
+
+type AgentSignalRequest struct {
+	Signal int `json:"signal"`
+}
+
+// Response of a wait request, Exited is false if the process is still running once the agent stops waiting
+type AgentWaitResponse struct {
+	Exited bool             `json:"exited"`
+	Error  *RemoteExitError `json:"error"`
+}
+
+// Response of a logs request, Lines are the output lines of the process numbered from the requested line on (lines the
+// agent no longer has are skipped). Exited is true once the process has exited and all of its output has been returned
+type AgentLogsResponse struct {
+	Lines  []string `json:"lines"`
+	Next   int      `json:"next"` // Number of the line after the last returned line, used as from by the next request
+	Exited bool     `json:"exited"`
+}
+
+// Response to an agent registration
+type AgentRegisterResponse struct {
+	Timeout int  `json:"timeout"` // “agents.timeout“ of the coordinator in seconds, the agent stops its processes once it has not registered with a leader for this long
+	Leader  bool `json:"leader"`  // Whether or not the coordinator is the leader, always true without “ha.enabled“
+}
+
+// Header used to authenticate requests between the coordinator and agents
+const AgentTokenHeader = "Authorization"
+
+// Time the coordinator waits on top of “agents.timeout“ before treating the processes of a lost agent as dead, so a
+// partitioned agent has stopped them (see AgentRegisterResponse) before they are started anywhere else
+const AgentFenceMargin = 20 * time.Second
+
+var errAgentProcessNotFound = errors.New("process not found on agent")
+
+// Returns the time without a heartbeat after which an agent is considered lost, “agents.timeout“
+func (l *InstanceList) agentTimeout() time.Duration {
+	return time.Second * time.Duration(*l.Config.Agents.Timeout)
+}
+
+// Registers an agent or records a heartbeat from it
+func (l *InstanceList) RegisterAgent(a Agent) AgentRegisterResponse {
+	leader := l.IsLeader()
+
+	l.agentsMutex.Lock()
+	defer l.agentsMutex.Unlock()
+
+	if l.agents == nil {
+		l.agents = map[string]*Agent{}
+	}
+
+	old, ok := l.agents[a.Name]
+
+	if !ok || time.Since(old.LastSeen) > l.agentTimeout() || old.URL != a.URL || old.Capacity != a.Capacity {
+		log.Info("Agent ", a.Name, " registered at ", a.URL, " with a capacity of ", a.Capacity, " shards")
+
+		go l.ActionLog(map[string]any{
+			"event":    "agent_registered",
+			"agent":    a.Name,
+			"url":      a.URL,
+			"capacity": a.Capacity,
+		})
+	}
+
+	a.LastSeen = time.Now()
+	l.agents[a.Name] = &a
+
+	return AgentRegisterResponse{
+		Timeout: int(l.agentTimeout() / time.Second),
+		Leader:  leader,
+	}
+}
+
+// Returns all agents which have registered, sorted by name
+func (l *InstanceList) Agents() []Agent {
+	l.agentsMutex.Lock()
+	defer l.agentsMutex.Unlock()
+
+	agents := make([]Agent, 0, len(l.agents))
+	for _, a := range l.agents {
+		agent := *a
+		agent.Alive = time.Since(a.LastSeen) <= l.agentTimeout()
+		agents = append(agents, agent)
+	}
+
+	sort.Slice(agents, func(x, y int) bool {
+		return agents[x].Name < agents[y].Name
+	})
+
+	return agents
+}
+
+// Returns an agent by name, or nil if it has not registered or is lost
+func (l *InstanceList) aliveAgent(name string) *Agent {
+	l.agentsMutex.Lock()
+	defer l.agentsMutex.Unlock()
+
+	a, ok := l.agents[name]
+
+	if !ok || time.Since(a.LastSeen) > l.agentTimeout() {
+		return nil
+	}
+
+	agent := *a
+	return &agent
+}
+
+// Returns true once the processes on an agent can be assumed to have been stopped by the agent itself, which happens
+// “agents.timeout“ after its last heartbeat (plus AgentFenceMargin). since is used instead of the last heartbeat if it is later
+func (l *InstanceList) agentFenced(name string, since time.Time) bool {
+	l.agentsMutex.Lock()
+	defer l.agentsMutex.Unlock()
+
+	if a, ok := l.agents[name]; ok && a.LastSeen.After(since) {
+		since = a.LastSeen
+	}
+
+	return time.Since(since) > l.agentTimeout()+AgentFenceMargin
+}
+
+// Waits until at least n agents are alive
+func (l *InstanceList) WaitForAgents(n int) {
+	for {
+		alive := 0
+		for _, a := range l.Agents() {
+			if a.Alive {
+				alive++
+			}
+		}
+
+		if alive >= n {
+			return
+		}
+
+		log.Info("Waiting for agents to register (", alive, "/", n, ")")
+
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Decides which host an instance should run on, returning nil if it should run on the coordinator
+//
+// The host the instance last ran on is preferred if it still has capacity, otherwise the host with the most free capacity is used
+func (l *InstanceList) PlaceCluster(i *Instance) (*Agent, error) {
+	if !l.Config.Agents.Enabled {
+		return nil, nil
+	}
+
+	used := map[string]uint64{}
+	for _, inst := range l.List() {
+		if p, _ := inst.current(); inst == i || p == nil || !inst.Running() {
+			continue
+		}
+
+		used[inst.host()] += uint64(len(inst.ShardList()))
+	}
+
+	need := uint64(len(i.ShardList()))
+	prev := i.host()
+
+	type host struct {
+		agent *Agent
+		free  uint64
+	}
+
+	var hosts []host
+
+	if l.Config.Agents.LocalCapacity >= used[""]+need {
+		hosts = append(hosts, host{free: l.Config.Agents.LocalCapacity - used[""]})
+	}
+
+	for _, a := range l.Agents() {
+		if !a.Alive || a.Capacity < used[a.Name]+need {
+			continue
+		}
+
+		agent := a
+		hosts = append(hosts, host{agent: &agent, free: a.Capacity - used[a.Name]})
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no host has capacity for the %d shards of cluster %d", need, i.ClusterID)
+	}
+
+	best := hosts[0]
+	for _, h := range hosts {
+		name := ""
+		if h.agent != nil {
+			name = h.agent.Name
+		}
+
+		if name == prev {
+			return h.agent, nil
+		}
+
+		if h.free > best.free {
+			best = h
+		}
+	}
+
+	return best.agent, nil
+}
+
+// Sends a request to the HTTP API of an agent, decoding the JSON response into resp if it is not nil
+func (l *InstanceList) agentRequest(name string, method string, path string, body any, resp any, timeout time.Duration) error {
+	a := l.aliveAgent(name)
+
+	if a == nil {
+		return fmt.Errorf("agent %s is not alive", name)
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+
+		if err != nil {
+			return err
+		}
+
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(a.URL, "/")+path, reqBody)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(AgentTokenHeader, l.Config.Agents.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{Timeout: timeout}
+
+	res, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return errAgentProcessNotFound
+	}
+
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("agent %s returned %s: %s", name, res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// Starts the process of an instance on an agent
+func (l *InstanceList) startOnAgent(a *Agent, i *Instance, cm *ClusterMap, args []string, env []string) error {
+	log.Info("Starting cluster ", cm.Name, " (", cm.ID, ") on agent ", a.Name)
+
+	sid := i.Session()
+
+	var res AgentStartResponse
+	err := l.agentRequest(a.Name, "POST", "/processes", AgentStartRequest{
+		SessionID:   sid,
+		ClusterID:   i.ClusterID,
+		ClusterName: cm.Name,
+		Args:        args,
+		Env:         env,
+		Dir:         l.Dir,
+	}, &res, 30*time.Second)
+
+	if err != nil {
+		return fmt.Errorf("could not start cluster on agent %s: %w", a.Name, err)
+	}
+
+	p := &remoteProcess{
+		l:     l,
+		agent: a.Name,
+		sid:   sid,
+		pid:   res.Pid,
+		since: time.Now(),
+	}
+
+	i.mu.Lock()
+	i.Process = p
+	i.Host = a.Name
+	i.Cgroup = ""
+	i.oomKills = 0
+	i.mu.Unlock()
+
+	go l.followAgentLogs(i, p)
+
+	return nil
+}
+
+// Copies the output of a process on an agent into the log buffer of its instance until the process has exited
+func (l *InstanceList) followAgentLogs(i *Instance, p *remoteProcess) {
+	buf := l.ClusterLogBuffer(i)
+	from := 0
+
+	for {
+		var res AgentLogsResponse
+		err := l.agentRequest(p.agent, "GET", "/processes/"+p.sid+"/logs?from="+strconv.Itoa(from), nil, &res, time.Minute)
+
+		if err != nil {
+			if errors.Is(err, errAgentProcessNotFound) || l.agentFenced(p.agent, p.since) {
+				return
+			}
+
+			log.Debug("Could not get output of process ", p.sid, " on agent ", p.agent, ": ", err)
+
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		for _, line := range res.Lines {
+			buf.Add(line)
+		}
+
+		from = res.Next
+
+		if res.Exited {
+			return
+		}
+	}
+}
+
+// A process running on an agent
+type remoteProcess struct {
+	l     *InstanceList
+	agent string // Name of the agent
+	sid   string // Session ID the process was started with, used to identify it on the agent
+	pid   int
+	since time.Time // When the process was started or adopted, the agent may not have registered yet when adopting
+}
+
+func (p *remoteProcess) Pid() int {
+	return p.pid
+}
+
+func (p *remoteProcess) Signal(sig syscall.Signal) error {
+	return p.l.agentRequest(p.agent, "POST", "/processes/"+p.sid+"/signal", AgentSignalRequest{Signal: int(sig)}, nil, 10*time.Second)
+}
+
+// Long-polls the agent until the process exits, returning an error if contact with the agent is lost
+//
+// Contact is only considered lost once the agent has fenced itself (see agentFenced), so the cluster is never restarted
+// elsewhere while its process may still be running on a partitioned agent
+func (p *remoteProcess) Wait() error {
+	for {
+		var res AgentWaitResponse
+		err := p.l.agentRequest(p.agent, "GET", "/processes/"+p.sid+"/wait", nil, &res, time.Minute)
+
+		if err == nil {
+			if !res.Exited {
+				continue
+			}
+
+			if res.Error != nil {
+				return res.Error
+			}
+
+			return nil
+		}
+
+		if errors.Is(err, errAgentProcessNotFound) {
+			return &RemoteExitError{ExitCode: -1, Message: "process no longer exists on agent " + p.agent}
+		}
+
+		if p.l.agentFenced(p.agent, p.since) {
+			return &RemoteExitError{ExitCode: -1, Message: "lost contact with agent " + p.agent}
+		}
+
+		log.Debug("Could not wait on process ", p.sid, " on agent ", p.agent, ": ", err)
+
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (p *remoteProcess) Metrics() (*ProcessMetrics, error) {
+	var metrics ProcessMetrics
+	err := p.l.agentRequest(p.agent, "GET", "/processes/"+p.sid+"/metrics", nil, &metrics, 10*time.Second)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &metrics, nil
+}
@@ -0,0 +1,85 @@
+package proc
+
+import (
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (l *InstanceList) initChannels() {
+	l.chanOnce.Do(func() {
+		l.pingCheckStop = make(chan int)
+	})
+}
+
+func (l *InstanceList) pingCheckStopChannel() chan int {
+	l.initChannels()
+	return l.pingCheckStop
+}
+
+// Sets every bot run by this mewld process (including this one) when running several bots, so Exit can act on all of them
+func (l *InstanceList) SetBots(bots []*InstanceList) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.group = bots
+}
+
+// Returns every bot run by this mewld process, which is just this instance list unless SetBots was called
+func (l *InstanceList) Bots() []*InstanceList {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if len(l.group) == 0 {
+		return []*InstanceList{l}
+	}
+
+	return l.group
+}
+
+// Lets go of the clusters of every bot and exits mewld
+//
+// With “ha.enabled“ the leader lease is released and clusters are left running for the next leader, with
+// “adopt_clusters“ the state is saved and clusters are left running to be adopted once mewld is started again.
+// Otherwise (or if the state could not be saved) clusters are stopped
+func (l *InstanceList) Exit(code int) {
+	var wg sync.WaitGroup
+
+	for _, b := range l.Bots() {
+		wg.Add(1)
+		go func(b *InstanceList) {
+			defer wg.Done()
+			b.release()
+		}(b)
+	}
+
+	wg.Wait()
+
+	os.Exit(code)
+}
+
+func (l *InstanceList) release() {
+	switch {
+	case l.Config.HA.Enabled:
+		// Leave clusters running for the next leader to adopt
+		err := l.StepDown()
+
+		if err != nil {
+			log.Error("Could not step down as leader, stopping clusters instead: ", err)
+			l.KillAll()
+		}
+	case l.Config.AdoptClusters:
+		err := l.SaveState()
+
+		if err != nil {
+			log.Error("Could not save state, stopping clusters instead: ", err)
+			l.KillAll()
+			return
+		}
+
+		log.Info("State saved, leaving clusters running")
+	default:
+		l.KillAll()
+	}
+}
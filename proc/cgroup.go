@@ -0,0 +1,120 @@
+package proc
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Creates (or reuses) the cgroup of a cluster and applies the configured limits, returning the path to the cgroup
+func (l *InstanceList) setupCgroup(i *Instance) (string, error) {
+	cg := l.Config.Cgroup
+
+	err := os.MkdirAll(cg.Root, 0755)
+
+	if err != nil {
+		return "", fmt.Errorf("could not create cgroup root: %w", err)
+	}
+
+	limits := []struct {
+		controller string
+		file       string
+		value      string
+	}{
+		{"memory", "memory.max", cg.MemoryMax},
+		{"memory", "memory.high", cg.MemoryHigh},
+		{"cpu", "cpu.max", cg.CPUMax},
+		{"pids", "pids.max", cg.PidsMax},
+	}
+
+	// Controllers must be enabled on the root for them to be usable in the cluster cgroups
+	for _, limit := range limits {
+		if limit.value == "" {
+			continue
+		}
+
+		err = os.WriteFile(filepath.Join(cg.Root, "cgroup.subtree_control"), []byte("+"+limit.controller), 0644)
+
+		if err != nil {
+			// The root of a bot is nested in the configured root when running several bots, so the controller may
+			// first have to be enabled on the configured root
+			if os.WriteFile(filepath.Join(filepath.Dir(cg.Root), "cgroup.subtree_control"), []byte("+"+limit.controller), 0644) == nil {
+				err = os.WriteFile(filepath.Join(cg.Root, "cgroup.subtree_control"), []byte("+"+limit.controller), 0644)
+			}
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("could not enable %s controller on %s: %w", limit.controller, cg.Root, err)
+		}
+	}
+
+	path := filepath.Join(cg.Root, "cluster-"+strconv.Itoa(i.ClusterID))
+
+	err = os.MkdirAll(path, 0755)
+
+	if err != nil {
+		return "", fmt.Errorf("could not create cgroup: %w", err)
+	}
+
+	for _, limit := range limits {
+		if limit.value == "" {
+			continue
+		}
+
+		err = os.WriteFile(filepath.Join(path, limit.file), []byte(limit.value), 0644)
+
+		if err != nil {
+			return "", fmt.Errorf("could not set %s: %w", limit.file, err)
+		}
+	}
+
+	return path, nil
+}
+
+// Moves a process into a cgroup
+func cgroupAddProcess(path string, pid int) error {
+	return os.WriteFile(filepath.Join(path, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+}
+
+// Removes the cgroup of a stopped cluster, which fails if processes forked by the cluster are still running in it
+func (l *InstanceList) removeCgroup(i *Instance) {
+	i.mu.Lock()
+	path := i.Cgroup
+	i.Cgroup = ""
+	i.mu.Unlock()
+
+	if path == "" {
+		return
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Warn("Could not remove cgroup of cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, "): ", err)
+	}
+}
+
+// Returns the number of processes in a cgroup which were killed by the OOM killer
+func cgroupOOMKills(path string) (uint64, error) {
+	f, err := os.Open(filepath.Join(path, "memory.events"))
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+
+	return 0, scanner.Err()
+}
@@ -0,0 +1,155 @@
+package proc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"text/template"
+
+	"github.com/cheesycod/mewld/ipc"
+	"github.com/cheesycod/mewld/utils"
+)
+
+// Variables available to the “command“ and “cluster_env“ templates
+type CommandVars struct {
+	Shards      string // Shards of the cluster as a list, e.g. [0, 1, 2]
+	ShardCount  uint64 // Total number of shards
+	ClusterID   int
+	ClusterName string
+	Dir         string // The base directory of the bot
+	SessionID   string // Session ID of the process being started
+}
+
+// Returns the template variables for starting an instance
+func (l *InstanceList) CommandVars(i *Instance, cm *ClusterMap) CommandVars {
+	return CommandVars{
+		Shards:      utils.ToPyListUInt64(i.ShardList()),
+		ShardCount:  l.TotalShards(),
+		ClusterID:   i.ClusterID,
+		ClusterName: cm.Name,
+		Dir:         l.Dir,
+		SessionID:   i.Session(),
+	}
+}
+
+func renderTemplate(name string, text string, vars CommandVars) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, vars)
+
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Returns the argv used to start an instance
+//
+// If “command“ is set, every element is rendered as a template, otherwise the default
+// layout of [interp] module shards shard_count cluster_id cluster_name logging_code dir is used
+func (l *InstanceList) ClusterArgs(i *Instance, cm *ClusterMap) ([]string, error) {
+	vars := l.CommandVars(i, cm)
+
+	if len(l.Config.Command) == 0 {
+		// Log mode, depends on bot to handle it
+		loggingCode := "0"
+
+		args := []string{
+			vars.Shards,
+			utils.UInt64ToString(vars.ShardCount),
+			strconv.Itoa(vars.ClusterID),
+			vars.ClusterName,
+			loggingCode,
+			vars.Dir,
+		}
+
+		if l.Config.Interp != "" {
+			return append([]string{l.Config.Interp, l.Dir + "/" + l.Config.Module}, args...), nil
+		}
+
+		// If no interpreter, we use the full module as the executable path
+		return append([]string{l.Config.Module}, args...), nil
+	}
+
+	args := make([]string, 0, len(l.Config.Command))
+
+	for idx, arg := range l.Config.Command {
+		rendered, err := renderTemplate("command", arg, vars)
+
+		if err != nil {
+			return nil, fmt.Errorf("command[%d]: %w", idx, err)
+		}
+
+		args = append(args, rendered)
+	}
+
+	if args[0] == "" {
+		return nil, errors.New("command[0] must not be empty")
+	}
+
+	return args, nil
+}
+
+// Returns the rendered “cluster_env“ variables of an instance as KEY=VALUE pairs, sorted by key
+func (l *InstanceList) ClusterEnv(i *Instance, cm *ClusterMap) ([]string, error) {
+	vars := l.CommandVars(i, cm)
+
+	keys := make([]string, 0, len(l.Config.ClusterEnv))
+	for key := range l.Config.ClusterEnv {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		value, err := renderTemplate(key, l.Config.ClusterEnv[key], vars)
+
+		if err != nil {
+			return nil, fmt.Errorf("cluster_env %s: %w", key, err)
+		}
+
+		env = append(env, key+"="+value)
+	}
+
+	return env, nil
+}
+
+// Returns the standard “MEWLD_*“ environment variables (as KEY=VALUE pairs) passed to an instance by DefaultStart
+func (l *InstanceList) ClusterEnvironment(i *Instance, cm *ClusterMap) []string {
+	shards, _ := json.Marshal(i.ShardList())
+
+	env := []string{
+		"MEWLD_CHANNEL=" + l.Config.RedisChannel,
+		"MEWLD_CLUSTER_ID=" + strconv.Itoa(i.ClusterID),
+		"MEWLD_CLUSTER_NAME=" + cm.Name,
+		"MEWLD_SHARDS=" + string(shards),
+		"MEWLD_SHARD_COUNT=" + utils.UInt64ToString(l.TotalShards()),
+		"MEWLD_SESSION_ID=" + i.Session(),
+		"MEWLD_MAX_CONCURRENCY=" + utils.UInt64ToString(l.maxConcurrency()),
+	}
+
+	if d, ok := l.IPC.(ipc.Describer); ok {
+		env = append(env, "MEWLD_IPC_BACKEND="+d.Backend(), "MEWLD_IPC_ADDRESS="+d.Address())
+	}
+
+	if i.IsStandby() {
+		env = append(env, "MEWLD_STANDBY=1")
+	}
+
+	if l.Config.IdentifyQueue.Enabled {
+		env = append(env, "MEWLD_IDENTIFY_QUEUE=http://"+l.Config.IdentifyQueue.Addr)
+	}
+
+	return env
+}
@@ -4,55 +4,112 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"strconv"
+	"syscall"
 
 	"github.com/cheesycod/mewld/utils"
 	log "github.com/sirupsen/logrus"
 )
 
 func DefaultStart(l *InstanceList, i *Instance, cm *ClusterMap) error {
-	// Log mode, depends on bot to handle it
-	loggingCode := "0"
-
-	// Get interpreter/caller
-	var cmd *exec.Cmd
-	if l.Config.Interp != "" {
-		cmd = exec.Command(
-			l.Config.Interp,
-			l.Dir+"/"+l.Config.Module,
-			utils.ToPyListUInt64(i.Shards),
-			utils.UInt64ToString(l.ShardCount),
-			strconv.Itoa(i.ClusterID),
-			cm.Name,
-			loggingCode,
-			l.Dir,
-		)
+	args, err := l.ClusterArgs(i, cm)
+
+	if err != nil {
+		return fmt.Errorf("could not render command: %w", err)
+	}
+
+	clusterEnv, err := l.ClusterEnv(i, cm)
+
+	if err != nil {
+		return fmt.Errorf("could not render cluster_env: %w", err)
+	}
+
+	agent, err := l.PlaceCluster(i)
+
+	if err != nil {
+		return err
+	}
+
+	if agent != nil {
+		// The agent adds these to its own environment
+		return l.startOnAgent(agent, i, cm, args, append(l.ClusterEnvironment(i, cm), clusterEnv...))
+	}
+
+	i.mu.Lock()
+	i.Host = ""
+	i.mu.Unlock()
+
+	cmd := exec.Command(args[0], args[1:]...)
+
+	// Clusters must survive mewld exiting both to be adopted after a restart and by the next leader
+	if l.Config.AdoptClusters || l.Config.HA.Enabled {
+		stdout, stderr, err := l.DetachedClusterOutput(i)
+
+		if err != nil {
+			return fmt.Errorf("could not open log file: %w", err)
+		}
+
+		if stdout != os.Stdout {
+			defer stdout.Close()
+		}
+
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+
+		// Keep the cluster out of the process group of mewld so it is not sent signals meant for mewld
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	} else {
-		cmd = exec.Command(
-			l.Config.Module, // If no interpreter, we use the full module as the executable path
-			utils.ToPyListUInt64(i.Shards),
-			utils.UInt64ToString(l.ShardCount),
-			strconv.Itoa(i.ClusterID),
-			cm.Name,
-			loggingCode,
-			l.Dir,
-		)
-	}
-
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+		cmd.Stdout, cmd.Stderr = l.ClusterOutput(i, cm)
+	}
+
 	cmd.Dir = l.Dir
 
 	env := os.Environ()
 
-	env = append(env, "MEWLD_CHANNEL="+l.Config.RedisChannel)
+	env = append(env, l.ClusterEnvironment(i, cm)...)
+
+	env = append(env, clusterEnv...)
 
 	cmd.Env = env
 
+	i.mu.Lock()
 	i.Command = cmd
+	i.mu.Unlock()
+
+	var cgroup string
+	var oomKills uint64
+	if l.Config.Cgroup.Enabled {
+		cgroup, err = l.setupCgroup(i)
+
+		if err != nil {
+			return fmt.Errorf("could not set up cgroup: %w", err)
+		}
+
+		oomKills, _ = cgroupOOMKills(cgroup)
+	}
 
 	// Spawn process
-	return cmd.Start()
+	err = cmd.Start()
+
+	if err != nil {
+		return err
+	}
+
+	if cgroup != "" {
+		// Moved into the cgroup right after starting, before the cluster had time to fork
+		err = cgroupAddProcess(cgroup, cmd.Process.Pid)
+
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return fmt.Errorf("could not move cluster into cgroup: %w", err)
+		}
+	}
+
+	i.mu.Lock()
+	i.Cgroup = cgroup
+	i.oomKills = oomKills
+	i.mu.Unlock()
+
+	return nil
 }
 
 func DefaultOnReshard(l *InstanceList, i *Instance, cm *ClusterMap, oldShards []uint64, newShards []uint64) error {
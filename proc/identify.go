@@ -0,0 +1,67 @@
+package proc
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// How long an identify uses up its max_concurrency bucket for
+const IdentifyWindow = 5 * time.Second
+
+// Internal state of a single identify rate limit bucket
+type identifyBucket struct {
+	lock   chan struct{} // Held (buffered channel of size 1) while a shard is waiting for its turn in the bucket
+	nextAt time.Time     // Time at which the bucket is free again
+}
+
+// Blocks until a shard is allowed to identify, one shard per “max_concurrency“ bucket (“shard_id % max_concurrency“) may identify every 5 seconds
+func (l *InstanceList) WaitForIdentify(ctx context.Context, shard uint64) error {
+	if count := l.TotalShards(); shard >= count {
+		return fmt.Errorf("shard %d is out of range, shard count is %d", shard, count)
+	}
+
+	maxConcurrency := l.maxConcurrency()
+
+	bucket := shard % maxConcurrency
+
+	l.identifyMutex.Lock()
+	if l.identifyBuckets == nil {
+		l.identifyBuckets = map[uint64]*identifyBucket{}
+	}
+
+	b, ok := l.identifyBuckets[bucket]
+
+	if !ok {
+		b = &identifyBucket{
+			lock: make(chan struct{}, 1),
+		}
+		l.identifyBuckets[bucket] = b
+	}
+	l.identifyMutex.Unlock()
+
+	select {
+	case b.lock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	defer func() {
+		<-b.lock
+	}()
+
+	if wait := time.Until(b.nextAt); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	b.nextAt = time.Now().Add(IdentifyWindow)
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package proc
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Returns the max_concurrency of the bot, at least 1
+func (l *InstanceList) maxConcurrency() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.GatewayBot.SessionStartLimit.MaxConcurrency == 0 {
+		return 1
+	}
+
+	return l.GatewayBot.SessionStartLimit.MaxConcurrency
+}
+
+// Returns the identify rate limit buckets (“shard_id % max_concurrency“) used by the shards of an instance
+func (l *InstanceList) Buckets(i *Instance) []uint64 {
+	maxConcurrency := l.maxConcurrency()
+
+	seen := map[uint64]bool{}
+	buckets := []uint64{}
+	for _, shard := range i.ShardList() {
+		b := shard % maxConcurrency
+
+		if !seen[b] {
+			seen[b] = true
+			buckets = append(buckets, b)
+		}
+	}
+
+	return buckets
+}
+
+// Returns true if any of the buckets is being used by a launching cluster, launchMutex must be held
+func (l *InstanceList) bucketsHeld(buckets []uint64) bool {
+	for _, b := range buckets {
+		if _, ok := l.bucketHolders[b]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Starts a cluster queued by StartNext after waiting for the identify window of its buckets to end
+func (l *InstanceList) launch(i *Instance, wait time.Duration) {
+	log.Info("Going to start *next* cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") after delay of ", wait.Round(time.Millisecond), " due to concurrency")
+
+	time.Sleep(wait)
+
+	i.AcquireLockAndLock(l, "StartNext")
+
+	err := l.Start(i)
+
+	i.Unlock()
+
+	l.launchMutex.Lock()
+	i.queued = false
+
+	if err != nil {
+		// The cluster will never send launch_next, so its buckets must be released here
+		for b, id := range l.bucketHolders {
+			if id == i.ClusterID {
+				delete(l.bucketHolders, b)
+			}
+		}
+	}
+	l.launchMutex.Unlock()
+
+	if err != nil {
+		log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") start failure: ", err)
+		go l.ActionLog(map[string]any{
+			"event": "cluster_start_failed",
+			"via":   "start_next",
+		})
+	}
+}
+
+// Policies for clusters which do not send launch_next within “launch_timeout“ seconds
+const (
+	LaunchTimeoutRestart = "restart" // Restart the cluster, the cluster is skipped instead if it is crash looping
+	LaunchTimeoutSkip    = "skip"    // Treat the cluster as launched so the next clusters can be started
+)
+
+// Waits for a cluster to send launch_next, restarting or skipping it if it does not do so within “launch_timeout“ seconds
+func (l *InstanceList) LaunchCheck(i *Instance, sid string) {
+	if *l.Config.LaunchTimeout <= 0 {
+		return
+	}
+
+	time.Sleep(time.Second * time.Duration(*l.Config.LaunchTimeout))
+
+	if current := i.Session(); current == "" || sid != current || i.Launched() {
+		return
+	}
+
+	policy := l.Config.LaunchTimeoutPolicy
+
+	if policy != LaunchTimeoutSkip {
+		policy = LaunchTimeoutRestart
+	}
+
+	log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") did not send launch_next within ", *l.Config.LaunchTimeout, " seconds (policy: ", policy, ")")
+
+	go l.ActionLog(map[string]any{
+		"event":  "launch_timeout",
+		"id":     i.ClusterID,
+		"policy": policy,
+	})
+
+	// Cordoned clusters are never restarted automatically, so they are skipped
+	if i.Status() != StateCordoned {
+		l.Transition(i, StateUnhealthy, "launch_timeout")
+	} else {
+		policy = LaunchTimeoutSkip
+	}
+
+	if policy == LaunchTimeoutRestart {
+		restarted, err := l.autoRestart(i, sid, "LaunchCheck", "launch_timeout")
+
+		if err == ErrLockedInstance || restarted {
+			return
+		}
+	}
+
+	log.Warn("Skipping cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") in the launch sequence")
+
+	// The cluster was not restarted, skip it so the launch sequence (or rolling restart) continues
+	i.mu.Lock()
+	i.LaunchTimedOut = true
+	i.mu.Unlock()
+
+	if l.IsRollRestarting() {
+		l.NotifyRollRestart(i.ClusterID)
+		return
+	}
+
+	l.StartNext()
+}
@@ -0,0 +1,133 @@
+package proc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cheesycod/mewld/ipc"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Returns the ID of this mewld instance, “ha.node_id“ (default hostname-pid)
+func (l *InstanceList) NodeID() string {
+	return l.Config.HA.NodeID
+}
+
+// Returns true if this mewld instance should manage clusters, which is always the case if high availability is disabled
+func (l *InstanceList) IsLeader() bool {
+	if !l.Config.HA.Enabled {
+		return true
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.Leader
+}
+
+func (l *InstanceList) setLeader(leader bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Leader = leader
+}
+
+func (l *InstanceList) leaseTTL() time.Duration {
+	return time.Second * time.Duration(*l.Config.HA.LeaseTTL)
+}
+
+// Competes for the leader lease, should be called as a seperate goroutine
+//
+// The lease is renewed every third of “ha.lease_ttl“ while this instance is the leader, along with saving the state of the
+// instance list. Once this instance becomes the leader, the state saved by the previous leader is adopted (see Adopt) and
+// clusters which were not adopted are started. If the lease is lost, mewld exits (without stopping clusters) so it can no
+// longer act on clusters managed by the new leader
+func (l *InstanceList) RunElection() error {
+	leaser, ok := l.IPC.(ipc.Leaser)
+
+	if !ok {
+		return errors.New("ipc backend does not support leader election")
+	}
+
+	ttl := l.leaseTTL()
+	id := l.NodeID()
+
+	var lastRenewed time.Time
+
+	for {
+		acquired, err := leaser.AcquireLease("leader", id, ttl)
+		leader := l.IsLeader()
+
+		switch {
+		case err != nil:
+			log.Error("Could not acquire leader lease: ", err)
+
+			if leader && time.Since(lastRenewed) > ttl {
+				log.Fatal("Could not renew the leader lease before it expired, exiting so the new leader can take over")
+			}
+		case acquired && !leader:
+			lastRenewed = time.Now()
+			l.setLeader(true)
+
+			log.Info("Elected as leader (", id, ")")
+
+			go l.ActionLog(map[string]any{
+				"event": "leader_elected",
+				"node":  id,
+			})
+
+			go l.takeOver()
+		case acquired:
+			lastRenewed = time.Now()
+
+			if err := l.SaveState(); err != nil {
+				log.Error("Could not save state: ", err)
+			}
+		case leader:
+			log.Fatal("Lost the leader lease, exiting so the new leader can take over")
+		}
+
+		time.Sleep(ttl / 3)
+	}
+}
+
+// Adopts the state of the previous leader and starts clusters which were not adopted
+func (l *InstanceList) takeOver() {
+	if state := l.LoadState(); state != nil {
+		log.Info("Adopting state saved by ", state.NodeID, " at ", state.SavedAt)
+
+		adopted := l.Adopt(state)
+
+		log.Info("Adopted ", adopted, " clusters")
+	}
+
+	if err := l.SaveState(); err != nil {
+		log.Error("Could not save state: ", err)
+	}
+
+	l.StartNext()
+}
+
+// Saves the state and releases the leader lease without stopping clusters, so another instance can adopt them immediately
+func (l *InstanceList) StepDown() error {
+	if !l.IsLeader() {
+		return nil
+	}
+
+	leaser, ok := l.IPC.(ipc.Leaser)
+
+	if !ok {
+		return errors.New("ipc backend does not support leader election")
+	}
+
+	err := l.SaveState()
+
+	if err != nil {
+		return err
+	}
+
+	l.setLeader(false)
+
+	return leaser.ReleaseLease("leader", l.NodeID())
+}
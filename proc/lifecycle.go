@@ -0,0 +1,216 @@
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Lifecycle state of an instance
+type LifecycleState string
+
+const (
+	StateStopped   LifecycleState = "stopped"   // The cluster is not running
+	StateStarting  LifecycleState = "starting"  // The start function of the cluster is running
+	StateLaunching LifecycleState = "launching" // The process of the cluster is running but has not sent launch_next yet
+	StateReady     LifecycleState = "ready"     // The cluster has sent launch_next and responds to ping checks
+	StateUnhealthy LifecycleState = "unhealthy" // The cluster did not send launch_next in time or does not respond to ping checks
+	StateStopping  LifecycleState = "stopping"  // The cluster is being stopped
+	StateCrashed   LifecycleState = "crashed"   // The cluster died, was stopped for being unhealthy or could not be started
+	StateCordoned  LifecycleState = "cordoned"  // The cluster is excluded from automatic restarts, rolling restarts, recycling and StartNext
+)
+
+// Valid transitions between lifecycle states
+var transitions = map[LifecycleState][]LifecycleState{
+	StateStopped:   {StateStarting, StateCordoned},
+	StateStarting:  {StateLaunching, StateCrashed},
+	StateLaunching: {StateReady, StateUnhealthy, StateStopping, StateCrashed, StateCordoned},
+	StateReady:     {StateUnhealthy, StateStopping, StateCrashed, StateCordoned},
+	StateUnhealthy: {StateReady, StateStopping, StateCrashed, StateCordoned},
+	StateStopping:  {StateStopped, StateCrashed, StateCordoned},
+	StateCrashed:   {StateStarting, StateStopped, StateCordoned},
+	StateCordoned:  {StateLaunching, StateReady, StateStopping, StateStopped},
+}
+
+var ErrInvalidTransition = errors.New("invalidTransitionError")
+
+// Returns the lifecycle state of an instance
+func (i *Instance) Status() LifecycleState {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if i.State == "" {
+		return StateStopped
+	}
+
+	return i.State
+}
+
+// Returns true if the process of the instance is running and is not being stopped
+func (i *Instance) Running() bool {
+	switch i.Status() {
+	case StateLaunching, StateReady, StateUnhealthy:
+		return true
+	case StateCordoned:
+		return i.processAlive()
+	}
+
+	return false
+}
+
+// Returns true if the current process of the instance has been started and has not exited yet
+func (i *Instance) processAlive() bool {
+	p, exit := i.current()
+
+	if p == nil || exit == nil {
+		return false
+	}
+
+	select {
+	case <-exit.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Moves an instance to a new lifecycle state, creating a “cluster_state_changed“ action log and calling “LoaderData.OnStateChange“
+//
+// Transitions not listed in transitions are refused with ErrInvalidTransition, transitions to the current state are ignored
+func (l *InstanceList) Transition(i *Instance, to LifecycleState, reason string) error {
+	i.mu.Lock()
+
+	from := i.State
+
+	if from == "" {
+		from = StateStopped
+	}
+
+	if from == to {
+		i.mu.Unlock()
+		return nil
+	}
+
+	valid := false
+	for _, s := range transitions[from] {
+		if s == to {
+			valid = true
+			break
+		}
+	}
+
+	if !valid {
+		i.mu.Unlock()
+		log.Error("Cluster ", i.ClusterID, " cannot move from ", from, " to ", to, " (", reason, ")")
+		return fmt.Errorf("%w: cluster %d cannot move from %s to %s", ErrInvalidTransition, i.ClusterID, from, to)
+	}
+
+	i.State = to
+	i.StateChangedAt = time.Now()
+
+	i.mu.Unlock()
+
+	log.Info("Cluster ", i.ClusterID, " is now ", to, " (was ", from, ", ", reason, ")")
+
+	go l.ActionLog(map[string]any{
+		"event":  "cluster_state_changed",
+		"id":     i.ClusterID,
+		"from":   from,
+		"to":     to,
+		"reason": reason,
+	})
+
+	if l.LoaderData.OnStateChange != nil {
+		l.LoaderData.OnStateChange(l, i, from, to)
+	}
+
+	return nil
+}
+
+// Moves a launching or unhealthy instance which has sent launch_next to the ready state
+func (l *InstanceList) MarkLaunched(i *Instance) {
+	i.mu.Lock()
+	i.LaunchedFully = true
+	i.mu.Unlock()
+
+	switch i.Status() {
+	case StateLaunching, StateUnhealthy:
+		l.Transition(i, StateReady, "launch_next")
+	}
+}
+
+// Cordons an instance, excluding it from automatic restarts, rolling restarts, recycling and StartNext until it is uncordoned
+func (l *InstanceList) Cordon(i *Instance) error {
+	return l.Transition(i, StateCordoned, "cordon")
+}
+
+// Uncordons an instance, moving it back to the state matching its process
+func (l *InstanceList) Uncordon(i *Instance) error {
+	if i.Status() != StateCordoned {
+		return fmt.Errorf("cluster %d is not cordoned", i.ClusterID)
+	}
+
+	switch {
+	case !i.processAlive():
+		return l.Transition(i, StateStopped, "uncordon")
+	case i.Launched():
+		return l.Transition(i, StateReady, "uncordon")
+	default:
+		return l.Transition(i, StateLaunching, "uncordon")
+	}
+}
+
+// Attempts to take the operation lock of the instance, returning ErrLockedInstance if another operation holds it
+//
+// Operations which start, stop or replace a cluster must hold this lock while doing so
+func (i *Instance) Lock(l *InstanceList, subsystem string) error {
+	if !i.opMutex.TryLock() {
+		holder := i.LockHolder()
+
+		log.Error("Cluster ", i.ClusterID, " is already locked by ", holder, ", ", subsystem, " cannot lock it")
+		go l.ActionLog(map[string]any{
+			"event":     "instance_locked_error",
+			"id":        i.ClusterID,
+			"subsystem": subsystem,
+			"holder":    holder,
+		})
+		return ErrLockedInstance
+	}
+
+	i.setLockHolder(subsystem)
+
+	return nil
+}
+
+// Waits for the operation lock of the instance and takes it
+func (i *Instance) AcquireLockAndLock(l *InstanceList, subsystem string) {
+	i.opMutex.Lock()
+	i.setLockHolder(subsystem)
+}
+
+// Releases the operation lock of the instance
+func (i *Instance) Unlock() {
+	i.setLockHolder("")
+	i.opMutex.Unlock()
+}
+
+// Returns true if an operation holds the lock of the instance
+func (i *Instance) Locked() bool {
+	return i.LockHolder() != ""
+}
+
+// Returns the subsystem holding the lock of the instance, or an empty string if it is not locked
+func (i *Instance) LockHolder() string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.LockedBy
+}
+
+func (i *Instance) setLockHolder(subsystem string) {
+	i.mu.Lock()
+	i.LockedBy = subsystem
+	i.mu.Unlock()
+}
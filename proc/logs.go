@@ -0,0 +1,425 @@
+package proc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A log file which is rotated once it grows too large or too old
+type RotatingFile struct {
+	Path       string        // Path to the current log file
+	MaxSize    int64         // Rotate once the file reaches this many bytes, 0 disables size based rotation
+	MaxAge     time.Duration // Rotate once the file has been written to for this long, 0 disables age based rotation
+	MaxBackups int           // Number of rotated files to keep, 0 keeps all of them
+	Compress   bool          // Whether or not to gzip rotated files
+
+	mu        sync.Mutex
+	cleanupMu sync.Mutex // Serializes compression and removal of rotated files
+	f         *os.File
+	size      int64
+	openedAt  time.Time
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.size > 0 && ((r.MaxSize > 0 && r.size+int64(len(p)) > r.MaxSize) || (r.MaxAge > 0 && time.Since(r.openedAt) > r.MaxAge)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+// Closes the current log file, it will be reopened on the next write
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+
+	err := r.f.Close()
+	r.f = nil
+
+	return err
+}
+
+func (r *RotatingFile) open() error {
+	err := os.MkdirAll(filepath.Dir(r.Path), 0755)
+
+	if err != nil {
+		return fmt.Errorf("could not create log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+
+	st, err := f.Stat()
+
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not stat log file: %w", err)
+	}
+
+	r.f = f
+	r.size = st.Size()
+	r.openedAt = time.Now()
+
+	return nil
+}
+
+func (r *RotatingFile) rotate() error {
+	err := r.f.Close()
+	r.f = nil
+
+	if err != nil {
+		return fmt.Errorf("could not close log file: %w", err)
+	}
+
+	ext := filepath.Ext(r.Path)
+	rotated := strings.TrimSuffix(r.Path, ext) + "." + time.Now().Format("2006-01-02T15-04-05.000") + ext
+
+	for n := 1; fileExists(rotated) || fileExists(rotated+".gz"); n++ {
+		rotated = strings.TrimSuffix(r.Path, ext) + "." + time.Now().Format("2006-01-02T15-04-05.000") + "-" + strconv.Itoa(n) + ext
+	}
+
+	err = os.Rename(r.Path, rotated)
+
+	if err != nil {
+		return fmt.Errorf("could not rotate log file: %w", err)
+	}
+
+	go r.cleanup(rotated)
+
+	return r.open()
+}
+
+// Compresses a freshly rotated file and removes rotated files over MaxBackups
+func (r *RotatingFile) cleanup(rotated string) {
+	r.cleanupMu.Lock()
+	defer r.cleanupMu.Unlock()
+
+	if r.Compress {
+		if err := gzipFile(rotated); err != nil {
+			log.Error("Could not compress rotated log file ", rotated, ": ", err)
+		}
+	}
+
+	if r.MaxBackups <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(r.Path)
+	backups, err := filepath.Glob(strings.TrimSuffix(r.Path, ext) + ".*" + ext + "*")
+
+	if err != nil {
+		log.Error("Could not list rotated log files: ", err)
+		return
+	}
+
+	// Rotated files are suffixed with a timestamp so sorting them by name sorts them by age
+	sort.Strings(backups)
+
+	for len(backups) > r.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			log.Error("Could not remove old log file ", backups[0], ": ", err)
+		}
+		backups = backups[1:]
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Longest line kept by a LineWriter, longer lines are split so a cluster which never writes a newline cannot grow the buffer forever
+const maxLineLength = 64 * 1024
+
+// Splits output into lines, calling onLine for every complete line
+type LineWriter struct {
+	mu     sync.Mutex
+	buf    []byte
+	onLine func(line string)
+}
+
+// Creates a LineWriter, Flush must be called once the process writing to it has exited
+func NewLineWriter(onLine func(line string)) *LineWriter {
+	return &LineWriter{onLine: onLine}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+
+		if idx >= 0 && idx <= maxLineLength {
+			w.onLine(strings.TrimSuffix(string(w.buf[:idx]), "\r"))
+			w.buf = w.buf[idx+1:]
+			continue
+		}
+
+		if len(w.buf) < maxLineLength {
+			break
+		}
+
+		// Emit the start of an overlong line as a line of its own
+		w.onLine(string(w.buf[:maxLineLength]))
+		w.buf = w.buf[maxLineLength:]
+	}
+
+	return len(p), nil
+}
+
+// Emits the buffered output not ending in a newline as a line, called once the process has exited
+func (w *LineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.onLine(strings.TrimSuffix(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
+
+// A fixed size in-memory buffer of the most recent output lines of a cluster, new lines can be subscribed to
+type LogBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int  // Index the next line is written to
+	full  bool // Whether or not the buffer has wrapped around
+	subs  map[chan string]struct{}
+}
+
+func NewLogBuffer(size int) *LogBuffer {
+	if size <= 0 {
+		size = 1
+	}
+
+	return &LogBuffer{
+		lines: make([]string, size),
+		subs:  map[chan string]struct{}{},
+	}
+}
+
+// Adds a line to the buffer, sending it to all subscribers
+func (b *LogBuffer) Add(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for ch := range b.subs {
+		// Slow subscribers miss lines instead of blocking the cluster
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
+
+// Returns the last n lines in the buffer (oldest first), n <= 0 returns all lines
+func (b *LogBuffer) Last(n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var lines []string
+	if b.full {
+		lines = append(lines, b.lines[b.next:]...)
+	}
+	lines = append(lines, b.lines[:b.next]...)
+
+	if n > 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+
+	return lines
+}
+
+// Subscribes to new lines, the returned function must be called to unsubscribe
+func (b *LogBuffer) Subscribe() (<-chan string, func()) {
+	ch := make(chan string, 100)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// Returns the in-memory log buffer of an instance, creating it if needed
+func (l *InstanceList) ClusterLogBuffer(i *Instance) *LogBuffer {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.Logs != nil {
+		return i.Logs
+	}
+
+	i.Logs = NewLogBuffer(*l.Config.ClusterLogs.BufferLines)
+
+	return i.Logs
+}
+
+// Returns the log file of an instance, creating it if needed. Returns nil if cluster log files are disabled
+func (l *InstanceList) clusterLogFile(i *Instance) *RotatingFile {
+	cl := &l.Config.ClusterLogs
+
+	if cl.Dir == "" {
+		return nil
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.logFile != nil {
+		return i.logFile
+	}
+
+	dir := cl.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(l.Dir, dir)
+	}
+
+	i.logFile = &RotatingFile{
+		Path:       filepath.Join(dir, fmt.Sprintf("cluster-%d.log", i.ClusterID)),
+		MaxSize:    int64(*cl.MaxSize) * 1024 * 1024,
+		MaxAge:     time.Hour * time.Duration(cl.MaxAge),
+		MaxBackups: cl.MaxBackups,
+		Compress:   cl.Compress,
+	}
+
+	return i.logFile
+}
+
+// Returns writers for the stdout and stderr of a cluster
+//
+// Every line is added to the log buffer of the instance, then prefixed with the name and ID of the
+// cluster, written to the log file of the cluster (if enabled) and mirrored to the console of mewld (if enabled)
+func (l *InstanceList) ClusterOutput(i *Instance, cm *ClusterMap) (stdout io.Writer, stderr io.Writer) {
+	console := *l.Config.ClusterLogs.Console
+	file := l.clusterLogFile(i)
+	buf := l.ClusterLogBuffer(i)
+	prefix := "[" + cm.Name + " (" + strconv.Itoa(cm.ID) + ")] "
+
+	output := func(mirror io.Writer) io.Writer {
+		return NewLineWriter(func(line string) {
+			buf.Add(line)
+
+			line = prefix + line + "\n"
+
+			if file != nil {
+				if _, err := file.Write([]byte(line)); err != nil {
+					log.Error("Could not write to log file of cluster ", cm.Name, " (", cm.ID, "): ", err)
+				}
+			}
+
+			if console {
+				mirror.Write([]byte(line))
+			}
+		})
+	}
+
+	return output(os.Stdout), output(os.Stderr)
+}
+
+// Returns files for the stdout and stderr of a cluster which must outlive mewld (“adopt_clusters“ or “ha.enabled“)
+//
+// Output is written directly to the log file of the cluster if enabled and to the console of mewld otherwise, as a pipe to mewld
+// would break once it exits. Lines are neither prefixed, buffered nor rotated by mewld in this mode. The returned files must be
+// closed by the caller once the process has been started
+func (l *InstanceList) DetachedClusterOutput(i *Instance) (stdout *os.File, stderr *os.File, err error) {
+	file := l.clusterLogFile(i)
+
+	if file == nil {
+		return os.Stdout, os.Stderr, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(file.Path), 0755)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.OpenFile(file.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return f, f, nil
+}
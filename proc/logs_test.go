@@ -0,0 +1,98 @@
+package proc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	long := strings.Repeat("a", maxLineLength)
+
+	tests := []struct {
+		name   string
+		writes []string
+		lines  []string
+	}{
+		{"lines", []string{"a\nb\r\n", "c\n"}, []string{"a", "b", "c"}},
+		{"split writes", []string{"a", "b\nc", "d\n"}, []string{"ab", "cd"}},
+		{"trailing partial line", []string{"a\nb"}, []string{"a", "b"}},
+		{"line at the limit", []string{long + "\n"}, []string{long}},
+		{"overlong line", []string{long + "bc\n"}, []string{long, "bc"}},
+		{"overlong output without newlines", []string{long, long, "b"}, []string{long, long, "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines []string
+			w := NewLineWriter(func(line string) { lines = append(lines, line) })
+
+			for _, p := range tt.writes {
+				w.Write([]byte(p))
+
+				if len(w.buf) >= maxLineLength {
+					t.Fatalf("buffer grew to %d bytes", len(w.buf))
+				}
+			}
+
+			w.Flush()
+
+			if strings.Join(lines, "|") != strings.Join(tt.lines, "|") {
+				t.Errorf("got %d lines %q, want %d lines %q", len(lines), short(lines), len(tt.lines), short(tt.lines))
+			}
+		})
+	}
+}
+
+func TestRotatingFileMaxSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int64
+		files   int // Log files expected after the writes, including the current one
+	}{
+		{"no size limit", 0, 1},
+		{"under the limit", 1024, 1},
+		{"over the limit", 8, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			r := &RotatingFile{Path: filepath.Join(dir, "cluster-0.log"), MaxSize: tt.maxSize}
+
+			for n := 0; n < 5; n++ {
+				if _, err := r.Write([]byte("line\n")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r.Close()
+
+			files, err := os.ReadDir(dir)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(files) != tt.files {
+				t.Errorf("got %d log files, want %d", len(files), tt.files)
+			}
+		})
+	}
+}
+
+// Shortens lines for error messages
+func short(lines []string) []string {
+	var s []string
+
+	for _, line := range lines {
+		if len(line) > 10 {
+			line = line[:10] + "..."
+		}
+
+		s = append(s, line)
+	}
+
+	return s
+}
@@ -0,0 +1,169 @@
+package proc
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Clock ticks per second used by /proc/<pid>/stat, this is 100 on practically all Linux systems
+const clockTicks = 100
+
+// A sample of the resource usage of a cluster, including all of its child processes
+type ProcessMetrics struct {
+	SampledAt time.Time `json:"sampled_at"`
+	Processes int       `json:"processes"` // Number of processes (the cluster and its children) sampled
+	RSS       uint64    `json:"rss"`       // Resident set size in bytes
+	CPUTime   float64   `json:"cpu_time"`  // User and system CPU time in seconds
+	Threads   uint64    `json:"threads"`   // Number of threads
+	FDs       uint64    `json:"fds"`       // Number of open file descriptors
+}
+
+// Internal stats of a single process read from /proc/<pid>/stat
+type procStat struct {
+	ppid    int
+	ticks   uint64 // utime + stime
+	threads uint64
+	rss     uint64 // In pages
+	start   uint64 // Start time in clock ticks since boot
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The process name may contain spaces/parentheses, so fields are only split after the last ')'
+	idx := strings.LastIndexByte(string(data), ')')
+
+	if idx < 0 {
+		return nil, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+
+	fields := strings.Fields(string(data[idx+1:]))
+
+	// fields[0] is field 3 (state) in proc(5)
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+
+	ppid, _ := strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	threads, _ := strconv.ParseUint(fields[17], 10, 64)
+	start, _ := strconv.ParseUint(fields[19], 10, 64)
+	rss, _ := strconv.ParseUint(fields[21], 10, 64)
+
+	return &procStat{
+		ppid:    ppid,
+		ticks:   utime + stime,
+		threads: threads,
+		rss:     rss,
+		start:   start,
+	}, nil
+}
+
+// Samples the resource usage of a process and all of its (transitive) child processes
+func SampleProcess(pid int) (*ProcessMetrics, error) {
+	root, err := readProcStat(pid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[int]*procStat{pid: root}
+
+	// Find child processes by walking the parent pids of every process
+	entries, err := os.ReadDir("/proc")
+
+	if err != nil {
+		return nil, err
+	}
+
+	children := map[int][]int{}
+	for _, e := range entries {
+		cpid, err := strconv.Atoi(e.Name())
+
+		if err != nil || cpid == pid {
+			continue
+		}
+
+		st, err := readProcStat(cpid)
+
+		if err != nil {
+			continue // The process may have exited in the meantime
+		}
+
+		stats[cpid] = st
+		children[st.ppid] = append(children[st.ppid], cpid)
+	}
+
+	pageSize := uint64(os.Getpagesize())
+	metrics := &ProcessMetrics{
+		SampledAt: time.Now(),
+	}
+
+	queue := []int{pid}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = append(queue[1:], children[p]...)
+
+		st := stats[p]
+		metrics.Processes++
+		metrics.RSS += st.rss * pageSize
+		metrics.CPUTime += float64(st.ticks) / clockTicks
+		metrics.Threads += st.threads
+
+		fds, err := os.ReadDir("/proc/" + strconv.Itoa(p) + "/fd")
+
+		if err == nil {
+			metrics.FDs += uint64(len(fds))
+		}
+	}
+
+	return metrics, nil
+}
+
+// Samples the resource usage of a cluster every “metrics_interval“ seconds, storing it on the instance
+func (l *InstanceList) MetricsCheck(i *Instance, sid string) {
+	if *l.Config.MetricsInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second * time.Duration(*l.Config.MetricsInterval))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if current := i.Session(); current == "" || sid != current {
+			return // Stop sampling if instance is stopped
+		}
+
+		p, _ := i.current()
+
+		if p == nil {
+			return
+		}
+
+		metrics, err := p.Metrics()
+
+		if err != nil {
+			log.Debug("Could not sample metrics of cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, "): ", err)
+			continue
+		}
+
+		i.mu.Lock()
+		i.Metrics = metrics
+		i.MetricsHistory = append(i.MetricsHistory, *metrics)
+
+		if len(i.MetricsHistory) > *l.Config.MetricsHistory {
+			i.MetricsHistory = i.MetricsHistory[len(i.MetricsHistory)-*l.Config.MetricsHistory:]
+		}
+		i.mu.Unlock()
+	}
+}
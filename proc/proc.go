@@ -22,11 +22,8 @@ import (
 )
 
 var (
-	RollRestartChannel = make(chan int)
-	DiagChannel        = make(chan DiagResponse)
-	PingCheckStop      = make(chan int) // Channel to stop the ping checker
-	ErrTimeout         = errors.New("timeoutError")
-	ErrLockedInstance  = errors.New("lockedInstanceError")
+	ErrTimeout        = errors.New("timeoutError")
+	ErrLockedInstance = errors.New("lockedInstanceError")
 )
 
 // Internal loader data, to make mewld embeddable and more extendible
@@ -34,6 +31,8 @@ type LoaderData struct {
 	Start       func(l *InstanceList, i *Instance, cm *ClusterMap) error                                         // Start function
 	OnReshard   func(l *InstanceList, i *Instance, cm *ClusterMap, oldShards []uint64, newShards []uint64) error // OnReshard function is called when the bot is resharded by mewld
 	OnActionLog func(payload map[string]any) error
+
+	OnStateChange func(l *InstanceList, i *Instance, from LifecycleState, to LifecycleState) // OnStateChange is called on every lifecycle state transition of an instance
 }
 
 // Gets gateway information from discord
@@ -86,31 +85,10 @@ func GetGatewayBot(c *config.CoreConfig) (*GatewayBot, error) {
 // Given a shard count, return the shards for each cluster (128 would be [[0, 1, ..., 9], [10, 11, ..., 19]])
 // However, if the shard count is not a multiple of the number of clusters, the last cluster will have fewer shards etc.
 // So, 1 would mean [[0]]
+//
+// This uses ContiguousStrategy, see GetClusterMap and InstanceList.BuildClusterMap for other shard strategies
 func GetClusterList(clusterNames []string, shards uint64, perCluster uint64) []ClusterMap {
-	var clusterMap []ClusterMap
-
-	var shardArr []uint64
-	var cid int = -1 // We start at -1 because we increment it before we use it
-	for i := uint64(0); i < shards; i++ {
-		if uint64(len(shardArr)) >= perCluster {
-			if cid >= len(clusterNames)-3 {
-				// Create a new cluster name using random string
-				clusterNames = append(clusterNames, utils.RandomString(10))
-			}
-			cid++
-			clusterMap = append(clusterMap, ClusterMap{ID: cid, Name: clusterNames[cid], Shards: shardArr})
-			shardArr = []uint64{}
-		}
-
-		shardArr = append(shardArr, i)
-	}
-
-	if len(shardArr) > 0 {
-		cid++
-		clusterMap = append(clusterMap, ClusterMap{ID: cid, Name: clusterNames[cid], Shards: shardArr})
-	}
-
-	return clusterMap
+	return GetClusterMap(clusterNames, shards, perCluster, ContiguousStrategy{})
 }
 
 // Represents a "cluster" of instances.
@@ -135,13 +113,16 @@ type GatewayBot struct {
 }
 
 // The final store of the ClusterMap list as well as a instance store
+//
+// Instances, Map, ShardCount, GatewayBot, LastClusterStartedAt, RollRestarting, FullyUp, Leader and RollingRestartProgress are
+// protected by the mutex of the instance list once it has been loaded. Use List, InstanceByID, Cluster and Snapshot to read them
 type InstanceList struct {
 	LastClusterStartedAt time.Time          `json:"LastClusterStartedAt"`
 	Map                  []ClusterMap       `json:"Map"`            // The list of clusters (ClusterMap) which defines how mewld will start clusters
 	Instances            []*Instance        `json:"Instances"`      // The list of instances (Instance) which are running
 	ShardCount           uint64             `json:"ShardCount"`     // The number of shards in ``mewld``
 	GatewayBot           GatewayBot         `json:"GetGatewayBot"`  // The response from Get Gateway Bot
-	Config               *config.CoreConfig `json:"-"`              // The configuration for ``mewld`` ANTIRAID-SPECIFIC: Don't marshal this into JSON
+	Config               *config.CoreConfig `json:"-"`              // The configuration for ``mewld``, read-only and with its defaults set (see config.SetDefaults) ANTIRAID-SPECIFIC: Don't marshal this into JSON
 	LoaderData           *LoaderData        `json:"-"`              // Internal loader data, to make mewld embeddable
 	Dir                  string             `json:"Dir"`            // The base directory instances will use when loading clusters
 	IPC                  ipc.Ipc            `json:"-"`              // IPC interface for mewld
@@ -149,81 +130,136 @@ type InstanceList struct {
 	StartMutex           sync.Mutex         `json:"-"`              // Internal mutex to prevent multiple instances from starting at the same time
 	RollRestarting       bool               `json:"RollRestarting"` // whether or not we are roll restarting (rolling restart)
 	FullyUp              bool               `json:"FullyUp"`        // whether or not we are fully up
+	Leader               bool               `json:"Leader"`         // whether or not this mewld instance holds the leader lease (with ha enabled)
+	SessionBudget        SessionBudget      `json:"SessionBudget"`  // Running session start budget
+
+	RollingRestartProgress *RollingRestartProgress `json:"RollingRestartProgress"` // Progress of the current (or last) rolling restart
+
+	recycleMutex  sync.Mutex           // Ensures only one cluster is recycled at a time
+	launchMutex   sync.Mutex           // Protects the launch state below and the queued flag of instances
+	bucketHolders map[uint64]int       // Identify buckets and the ID of the launching cluster using them
+	bucketFreeAt  map[uint64]time.Time // Time at which the identify window of a bucket ends
+
+	sessionMutex    sync.Mutex                 // Protects SessionBudget
+	identifyMutex   sync.Mutex                 // Protects identifyBuckets
+	identifyBuckets map[uint64]*identifyBucket // State of the identify queue per max_concurrency bucket
+
+	agentsMutex sync.Mutex        // Protects agents
+	agents      map[string]*Agent // Registered agents by name
+
+	diagMutex   sync.Mutex                   // Protects diagWaiters
+	diagWaiters map[string]chan DiagResponse // Pending ScanShards by nonce
+
+	mu            sync.RWMutex        // Protects the exported state of the instance list and the indexes below
+	instancesByID map[int]*Instance   // Instances by cluster ID, rebuilt by index when Instances changes
+	clustersByID  map[int]*ClusterMap // Entries of Map by cluster ID, rebuilt by index when Map changes
+	removed       map[int]*ClusterMap // Clusters removed from Map by SetInstances, so goroutines of removed instances can still look them up
+	group         []*InstanceList     // Every bot run by this mewld process, see SetBots
+	launchWaiters chan int            // Cluster IDs which sent launch_next (or were skipped), only set while a rolling restart waits on a batch
+
+	chanOnce      sync.Once
+	pingCheckStop chan int // Channel to stop the ping checker
 }
 
 // Represents a instance of a cluster
+//
+// The fields of an instance are protected by its mutex, as they are updated by the goroutines observing and checking the
+// cluster. Use the accessors of Instance (such as Session) or Snapshot to read them from outside of the proc package
 type Instance struct {
-	StartedAt        time.Time     `json:"StartedAt"`        // The time the instance was last started
-	SessionID        string        `json:"SessionID"`        // Internally used to identify the instance
-	ClusterID        int           `json:"ClusterID"`        // ClusterID from clustermap
-	Shards           []uint64      `json:"Shards"`           // Shards that this instance is responsible for currently, should be equal to clustermap
-	Command          *exec.Cmd     `json:"-"`                // Command that is running on the instance
-	Active           bool          `json:"Active"`           // Whether or not this instance is active
-	ClusterHealth    []ShardHealth `json:"ClusterHealth"`    // Cache of shard health from a ping
-	CurrentlyKilling bool          `json:"CurrentlyKilling"` // Whether or not we are currently killing this instance
-	LockClusterTime  *time.Time    `json:"LockClusterTime"`  // Time at which we last locked the cluster
-	LaunchedFully    bool          `json:"LaunchedFully"`    // Whether or not we have launched the instance fully (till launch_next)
-	LastChecked      time.Time     `json:"LastChecked"`      // The last time the shard was checked for health.
+	InstanceInfo
+
+	Command *exec.Cmd  `json:"-"` // Command that is running on the instance, only set for local processes started by DefaultStart
+	Process Process    `json:"-"` // Process of the instance, either local or on an agent
+	Logs    *LogBuffer `json:"-"` // Recent output of the cluster, only populated when using DefaultStart
+
+	exit        *processExit  // Closed once the current process of the instance exits
+	shutdownAck chan struct{} // Signalled when the cluster acknowledges a prepare_shutdown message
+	logFile     *RotatingFile // Log file the output of the cluster is written to, if enabled
+	oomKills    uint64        // OOM kills in the cgroup of the cluster when it was started
+	queued      bool          // Whether or not the instance is waiting to be started by StartNext
+	standby     *standby      // Standby process replacing the cluster, if any
+	isStandby   bool          // Whether or not this instance is a standby process started by Replace
+	opMutex     sync.Mutex    // Operation lock, see Lock
+	mu          sync.RWMutex  // Protects the other fields of the instance, except for ClusterID which never changes
 }
 
-type ShardHealth struct {
-	ShardID uint64  `json:"shard_id"` // The shard ID
-	Up      bool    `json:"up"`       // Whether or not the shard is up
-	Latency float64 `json:"latency"`  // Latency of the shard (optional, send if possible)
-	Guilds  uint64  `json:"guilds"`   // The number of guilds in the shard. Is optional
-	Users   uint64  `json:"users"`    // The number of users in the shard. Is optional
+// Information about an instance, copied by Snapshot
+type InstanceInfo struct {
+	StartedAt      time.Time     `json:"StartedAt"`      // The time the instance was last started
+	SessionID      string        `json:"SessionID"`      // Internally used to identify the instance
+	ClusterID      int           `json:"ClusterID"`      // ClusterID from clustermap
+	Shards         []uint64      `json:"Shards"`         // Shards that this instance is responsible for currently, should be equal to clustermap
+	Host           string        `json:"Host"`           // Name of the agent the instance runs on, empty if it runs on the coordinator
+	ClusterHealth  []ShardHealth `json:"ClusterHealth"`  // Cache of shard health from a ping
+	LaunchedFully  bool          `json:"LaunchedFully"`  // Whether or not we have launched the instance fully (till launch_next)
+	LastChecked    time.Time     `json:"LastChecked"`    // The last time the shard was checked for health.
+	RestartHistory []time.Time   `json:"RestartHistory"` // Times of recent automatic restarts, used for crash loop detection
+	CrashLooping   bool          `json:"CrashLooping"`   // Whether or not the instance is crash looping, automatic restarts are disabled while this is set
+	LaunchTimedOut bool          `json:"LaunchTimedOut"` // Whether or not the instance did not send launch_next within launch_timeout since it was last started
+	Cgroup         string        `json:"Cgroup"`         // Path to the cgroup of the cluster, if cgroup limits are enabled
+	Replacing      bool          `json:"Replacing"`      // Whether or not a standby process is being started to replace the cluster
+
+	State          LifecycleState `json:"State"`          // Lifecycle state of the instance, use Status to read it and Transition to change it
+	StateChangedAt time.Time      `json:"StateChangedAt"` // The time of the last lifecycle state transition
+	LockedBy       string         `json:"LockedBy"`       // Subsystem holding the operation lock of the instance, if any
+
+	Metrics        *ProcessMetrics  `json:"Metrics"`        // Latest resource usage sample of the cluster
+	MetricsHistory []ProcessMetrics `json:"MetricsHistory"` // Recent resource usage samples of the cluster (oldest first)
 }
 
-type DiagResponse struct {
-	Nonce string        // Random nonce used to validate that a nonce comes from a specific diag request
-	Data  []ShardHealth // The shard health data
-}
-
-// Returns true if the cluster is locked, otherwise false
-func (i *Instance) Locked() bool {
-	if i.LockClusterTime == nil || time.Since(*i.LockClusterTime) < time.Second*60 {
-		return false
+// Creates a stopped instance for a cluster
+func NewInstance(cm ClusterMap) *Instance {
+	return &Instance{
+		InstanceInfo: InstanceInfo{
+			SessionID: utils.RandomString(16),
+			ClusterID: cm.ID,
+			Shards:    cm.Shards,
+			State:     StateStopped,
+		},
 	}
+}
 
-	return true
+// Tracks the exit of a process, so both Observe and Stop can wait on it
+type processExit struct {
+	done chan struct{} // Closed once the process has exited
+	err  error         // The error returned by Wait, only valid after done is closed
 }
 
-// Attempts to lock the cluster from observing actions (such as shutdown/startup/rolling restart etc.)
-func (i *Instance) Lock(l *InstanceList, subsystem string, critical bool) error {
-	if i.Locked() && !critical {
-		log.Error("Instance is already locked")
-		go l.ActionLog(map[string]any{
-			"event":     "instance_locked_error",
-			"subsystem": subsystem,
-		})
-		return ErrLockedInstance
+// Waits on a process in the background, returning a processExit tracking it
+func watchExit(p Process) *processExit {
+	pe := &processExit{
+		done: make(chan struct{}),
 	}
-	lt := time.Now()
 
-	i.LockClusterTime = &lt
+	go func() {
+		pe.err = p.Wait()
 
-	return nil
-}
+		// Output is only split into lines on newlines, so the last line of a cluster would otherwise be lost
+		if lp, ok := p.(*localProcess); ok {
+			for _, w := range []io.Writer{lp.cmd.Stdout, lp.cmd.Stderr} {
+				if lw, ok := w.(*LineWriter); ok {
+					lw.Flush()
+				}
+			}
+		}
+
+		close(pe.done)
+	}()
 
-func (i *Instance) Unlock() {
-	i.LockClusterTime = nil
+	return pe
 }
 
-// Waits for the instance lock to end (if any), then returns thus acquiring the lock
-func (i *Instance) AcquireLock() {
-	for {
-		if i.Locked() {
-			time.Sleep(time.Millisecond * 100)
-			continue
-		}
-		break
-	}
+type ShardHealth struct {
+	ShardID uint64  `json:"shard_id"` // The shard ID
+	Up      bool    `json:"up"`       // Whether or not the shard is up
+	Latency float64 `json:"latency"`  // Latency of the shard (optional, send if possible)
+	Guilds  uint64  `json:"guilds"`   // The number of guilds in the shard. Is optional
+	Users   uint64  `json:"users"`    // The number of users in the shard. Is optional
 }
 
-// Acquires the lock and then locks it
-func (i *Instance) AcquireLockAndLock(l *InstanceList, subsystem string) {
-	i.AcquireLock()
-	i.Lock(l, subsystem, false)
+type DiagResponse struct {
+	Nonce string        // Random nonce used to validate that a nonce comes from a specific diag request
+	Data  []ShardHealth // The shard health data
 }
 
 // Internal payload for diagnostics
@@ -249,6 +285,22 @@ func (l *InstanceList) ScanShards(i *Instance) ([]ShardHealth, error) {
 		return nil, err
 	}
 
+	// Every scan gets its own channel, so concurrent scans do not receive (and drop) each others responses
+	response := make(chan DiagResponse, 1)
+
+	l.diagMutex.Lock()
+	if l.diagWaiters == nil {
+		l.diagWaiters = map[string]chan DiagResponse{}
+	}
+	l.diagWaiters[nonce] = response
+	l.diagMutex.Unlock()
+
+	defer func() {
+		l.diagMutex.Lock()
+		delete(l.diagWaiters, nonce)
+		l.diagMutex.Unlock()
+	}()
+
 	err = l.IPC.Write(diagBytes)
 
 	if err != nil {
@@ -256,26 +308,37 @@ func (l *InstanceList) ScanShards(i *Instance) ([]ShardHealth, error) {
 	}
 
 	// Wait for diagnostic message from channel with timeout
-	if l.Config.PingTimeout == nil {
-		l.Config.PingTimeout = utils.Pointer(120)
+	pt := *l.Config.PingTimeout
+
+	timer := time.NewTimer(time.Second * time.Duration(pt))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil, ErrTimeout
+	case diag := <-response:
+		i.mu.Lock()
+		i.LastChecked = time.Now()
+		i.mu.Unlock()
+
+		return diag.Data, nil
 	}
+}
 
-	pt := *l.Config.PingTimeout
+// Hands a diag response received over IPC to the ScanShards waiting on its nonce, responses for scans which timed out are dropped
+func (l *InstanceList) DeliverDiag(diag DiagResponse) {
+	l.diagMutex.Lock()
+	response := l.diagWaiters[diag.Nonce]
+	l.diagMutex.Unlock()
 
-	ticker := time.NewTicker(time.Second * time.Duration(pt))
+	if response == nil {
+		log.Warn("Dropping diag response with unknown nonce ", diag.Nonce)
+		return
+	}
 
-	for {
-		select {
-		case <-ticker.C:
-			ticker.Stop()
-			return nil, ErrTimeout
-		case diag := <-DiagChannel:
-			if diag.Nonce == nonce {
-				ticker.Stop()
-				i.LastChecked = time.Now()
-				return diag.Data, nil
-			}
-		}
+	select {
+	case response <- diag:
+	default:
 	}
 }
 
@@ -287,109 +350,168 @@ func (l *InstanceList) Reshard() error {
 		return errors.New("reshard not enabled")
 	}
 
-	if l.RollRestarting {
+	if l.IsRollRestarting() {
 		return fmt.Errorf("cannot reshard during a rolling restart")
 	}
 
-	if !l.FullyUp {
+	if !l.IsFullyUp() {
 		return fmt.Errorf("cannot safely reshard when not fully up")
 	}
 
+	instances := l.List()
+
 	// Lock all instances
-	for i := range l.Instances {
-		if l.Instances[i].Locked() {
-			return fmt.Errorf("cluster %d is locked", i)
+	for _, i := range instances {
+		if err := i.Lock(l, "Reshard"); err != nil {
+			return fmt.Errorf("cluster %d is locked by %s", i.ClusterID, i.LockHolder())
 		}
 
-		l.Instances[i].AcquireLockAndLock(l, "Reshard")
-		defer l.Instances[i].Unlock()
+		defer i.Unlock()
 	}
 
-	l.FullyUp = false
+	l.setFullyUp(false)
 
 	log.Println("Cluster names:", l.Config.Names)
 
-	// Get new shard count
-	gb, err := GetGatewayBot(l.Config)
+	// Get new shard count and cluster map
+	gb, clusterMap, err := l.reshardClusterMap()
 
 	if err != nil {
-		return fmt.Errorf("get gateway bot failed: %w", err)
+		return err
 	}
 
-	if l.Config.MinimumSafeSessionsRemaining == nil {
-		l.Config.MinimumSafeSessionsRemaining = utils.Pointer[uint64](5)
+	l.SyncSessionBudget(gb)
+
+	if gb.SessionStartLimit.Remaining < l.minimumSafeSessions() {
+		return fmt.Errorf("sessions remaining is less than config.minimum_safe_sessions_remaining")
 	}
 
-	mssr := *l.Config.MinimumSafeSessionsRemaining
+	// Cluster IDs are assigned in order, so existing instances keep their position. Instances past the end of the new cluster map are removed
+	kept, removed := instances, []*Instance{}
 
-	if gb.SessionStartLimit.Remaining < mssr {
-		return fmt.Errorf("sessions remaining is less than config.minimum_safe_sessions_remaining")
+	if len(clusterMap) < len(instances) {
+		kept, removed = instances[:len(clusterMap)], instances[len(clusterMap):]
 	}
 
-	log.Println("Recommended shard count:", gb.Shards)
+	newInstances := make([]*Instance, len(clusterMap))
+	copy(newInstances, kept)
 
-	if l.Config.FixedShardCount > 0 {
-		gb.Shards = l.Config.FixedShardCount
+	for idx := len(kept); idx < len(clusterMap); idx++ {
+		newInstances[idx] = NewInstance(clusterMap[idx])
+	}
+
+	// A shard must never run in two clusters at once, so every cluster still running one of the shards a cluster is
+	// started with is stopped first
+	oldShards := map[*Instance][]uint64{}
+	for _, i := range instances {
+		oldShards[i] = i.ShardList()
 	}
 
-	// Next set the cluster map correctly and roll restart clusters
-	var perCluster uint64 = l.Config.PerCluster
+	errorList := []error{}
 
-	clusterMap := GetClusterList(l.Config.Names, gb.Shards, perCluster)
+	stopOverlapping := func(shards []uint64) {
+		for _, o := range instances {
+			if oldShards[o] == nil || !sharesShards(oldShards[o], shards) {
+				continue
+			}
 
-	if len(clusterMap) < len(l.Instances) {
-		return fmt.Errorf("cannot safely reshard to a smaller cluster size")
+			if err := l.reshardStop(o); err != nil {
+				errorList = append(errorList, err)
+			}
+
+			oldShards[o] = nil
+		}
 	}
 
+	l.mu.Lock()
 	l.GatewayBot = *gb
-	l.Map = clusterMap
 	l.ShardCount = gb.Shards
+	l.mu.Unlock()
 
-	errorList := []error{}
+	l.SetInstances(clusterMap, newInstances)
 
-	for i, cMap := range clusterMap {
-		if i < len(l.Instances) {
-			l.Instances[i].ClusterID = cMap.ID // Always update Cluster ID. It doesn't hurt
+	for idx, cMap := range clusterMap {
+		i := newInstances[idx]
 
+		if idx < len(kept) {
 			if !l.Config.ReshardAll {
-				if utils.SlicesEqual(l.Instances[i].Shards, cMap.Shards) {
+				if utils.SlicesEqual(i.ShardList(), cMap.Shards) {
 					log.Info("Cluster ", cMap.Name, "("+strconv.Itoa(cMap.ID)+") UNCHANGED (same shards): ", utils.ToPyListUInt64(cMap.Shards))
-					continue // No need to reshard
+					oldShards[i] = nil // Keeps running its shards
+					continue           // No need to reshard
 				}
 			}
 
-			// We already have this cluster already, merely grow it, then restart the cluster
+			// We already have this cluster already, merely grow (or shrink) it, then restart the cluster
 			log.Info("Cluster ", cMap.Name, "("+strconv.Itoa(cMap.ID)+") EXPANDED/RESHARDED: ", utils.ToPyListUInt64(cMap.Shards))
-			l.Instances[i].Shards = cMap.Shards
-
-			err := l.Stop(l.Instances[i])
 
-			if err == StopCodeNormal {
-				err := l.Start(l.Instances[i])
+			stopOverlapping(cMap.Shards)
 
-				if err != nil {
-					log.Error("Cluster ", cMap.Name, "("+strconv.Itoa(cMap.ID)+") start failure: ", err)
-					errorList = append(errorList, fmt.Errorf("cluster %d start failure: %w", i, err))
+			if oldShards[i] != nil {
+				if err := l.reshardStop(i); err != nil {
+					errorList = append(errorList, err)
 				}
-			} else {
-				errorList = append(errorList, fmt.Errorf("cluster %d stop failure with exit code %d", i, err))
+
+				oldShards[i] = nil
+			}
+
+			i.mu.Lock()
+			i.Shards = cMap.Shards
+			i.mu.Unlock()
+
+			err := l.Start(i)
+
+			if err != nil {
+				log.Error("Cluster ", cMap.Name, "("+strconv.Itoa(cMap.ID)+") start failure: ", err)
+				errorList = append(errorList, fmt.Errorf("cluster %d start failure: %w", idx, err))
 			}
 		} else {
 			// We don't already have this cluster yet, add it
 			log.Info("Cluster ", cMap.Name, "("+strconv.Itoa(cMap.ID)+") CREATED: ", utils.ToPyListUInt64(cMap.Shards))
-			l.Instances = append(l.Instances, &Instance{
-				SessionID: utils.RandomString(16),
-				ClusterID: cMap.ID,
-				Shards:    cMap.Shards,
-			})
 
-			err = l.Start(l.Instances[i])
+			stopOverlapping(cMap.Shards)
+
+			i.AcquireLockAndLock(l, "Reshard")
+			err = l.Start(i)
+			i.Unlock()
 
 			if err != nil {
 				log.Error("Cluster ", cMap.Name, "("+strconv.Itoa(cMap.ID)+") start failure: ", err)
-				errorList = append(errorList, fmt.Errorf("cluster %d start failure: %w", i, err))
+				errorList = append(errorList, fmt.Errorf("cluster %d start failure: %w", idx, err))
+			}
+		}
+	}
+
+	// Surplus clusters whose shards were not moved yet (or no longer exist) are stopped last, highest cluster ID first
+	for idx := len(removed) - 1; idx >= 0; idx-- {
+		i := removed[idx]
+		cm := l.Cluster(i)
+
+		if oldShards[i] != nil {
+			if err := l.reshardStop(i); err != nil {
+				errorList = append(errorList, err)
 			}
 		}
+
+		log.Info("Cluster ", cm.Name, "("+strconv.Itoa(cm.ID)+") REMOVED: ", utils.ToPyListUInt64(cm.Shards))
+
+		// Crashed clusters are not stopped above, so their cgroup is still there
+		l.removeCgroup(i)
+
+		i.mu.Lock()
+		logFile := i.logFile
+		i.mu.Unlock()
+
+		if logFile != nil {
+			logFile.Close()
+		}
+
+		go l.ActionLog(map[string]any{
+			"event":  "cluster_removed",
+			"id":     i.ClusterID,
+			"name":   cm.Name,
+			"shards": cm.Shards,
+		})
 	}
 
 	if len(errorList) > 0 {
@@ -409,6 +531,63 @@ func (l *InstanceList) Reshard() error {
 	return nil
 }
 
+// Fetches the shard count from Get Gateway Bot and builds the cluster map for resharding to it, leaving the session start budget unchanged
+func (l *InstanceList) reshardClusterMap() (*GatewayBot, []ClusterMap, error) {
+	gb, err := GetGatewayBot(l.Config)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("get gateway bot failed: %w", err)
+	}
+
+	log.Println("Recommended shard count:", gb.Shards)
+
+	if l.Config.FixedShardCount > 0 {
+		gb.Shards = l.Config.FixedShardCount
+	}
+
+	clusterMap, err := l.BuildClusterMap(gb)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return gb, clusterMap, nil
+}
+
+// Stops an instance whose shards are being moved by Reshard, the lock of the instance must be held
+func (l *InstanceList) reshardStop(i *Instance) error {
+	if !i.processAlive() {
+		// Crashed clusters must not be restarted automatically with their old shards
+		if i.Status() == StateCrashed {
+			l.Transition(i, StateStopped, "reshard")
+		}
+
+		return nil
+	}
+
+	if code := l.Stop(i); code != StopCodeNormal {
+		return fmt.Errorf("cluster %d stop failure with exit code %d", i.ClusterID, code)
+	}
+
+	return nil
+}
+
+// Returns true if the shard lists have a shard in common
+func sharesShards(a []uint64, b []uint64) bool {
+	set := make(map[uint64]bool, len(a))
+	for _, shard := range a {
+		set[shard] = true
+	}
+
+	for _, shard := range b {
+		if set[shard] {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Creates a new action log for a cluster
 func (l *InstanceList) ActionLog(payload map[string]any) {
 	payload["ts"] = time.Now().UnixMicro()
@@ -457,144 +636,220 @@ func (l *InstanceList) SendMessage(cmdId string, payload any, scope string, acti
 	return l.IPC.Write(bytes)
 }
 
-// Begins a rolling restart, should be called as a seperate goroutine
-func (l *InstanceList) RollingRestart() {
-	if !l.FullyUp {
-		log.Error("Not fully up, not rolling restart")
-		return
-	}
-
-	go l.ActionLog(map[string]any{
-		"event": "rolling_restart",
-	})
-
-	l.RollRestarting = true
+// Starts the next clusters in the instance list if possible
+//
+// Clusters are grouped by the identify rate limit buckets (“shard_id % max_concurrency“) of their shards. A cluster
+// is only started once none of its buckets are used by a cluster which is still launching (has not sent launch_next)
+// and “cluster_start_next_delay“ seconds have passed since the last cluster using them finished launching. Clusters
+// with independent buckets are started concurrently
+func (l *InstanceList) StartNext() {
+	l.launchMutex.Lock()
+	defer l.launchMutex.Unlock()
 
-	for _, i := range l.Instances {
-		log.Info("Rolling restart on cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
+	// We are starting a new instance, so we are not fully up yet
+	l.setFullyUp(false)
 
-		i.AcquireLock()
+	if l.bucketHolders == nil {
+		l.bucketHolders = map[uint64]int{}
+		l.bucketFreeAt = map[uint64]time.Time{}
+	}
 
-		i.Lock(l, "RollingRestart", false)
+	snd := time.Second * time.Duration(*l.Config.ClusterStartNextDelay)
 
-		code := l.Stop(i)
+	// Release the buckets of clusters which have finished launching
+	for b, id := range l.bucketHolders {
+		i := l.InstanceByID(id)
 
-		if code == StopCodeRestartFailed {
-			log.Error("Rolling restart failed on cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
+		if i == nil {
+			delete(l.bucketHolders, b)
 			continue
 		}
 
-		// Now start cluster again
-		err := l.Start(i)
+		if launched, timedOut := i.launchStatus(); launched || timedOut || (!i.queued && !i.Running()) {
+			delete(l.bucketHolders, b)
+			l.bucketFreeAt[b] = time.Now().Add(snd)
+		}
+	}
 
-		if err != nil {
-			log.Error("Rolling restart failed on cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
-			go l.ActionLog(map[string]any{
-				"event": "cluster_restart_failed",
-				"via":   "rolling_restart",
-			})
+	// Get next instances to start
+	pending := false
+	for _, i := range l.List() {
+		if p, _ := i.current(); i.queued || p != nil || i.Status() == StateCordoned {
+			continue
 		}
 
-		i.Unlock()
+		pending = true
 
-		for {
-			id := <-RollRestartChannel
+		buckets := l.Buckets(i)
 
-			if id != i.ClusterID {
-				log.Info("Ignoring restart of cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, "). Waiting for cluster ", id, " to restart")
-			} else {
-				break
-			}
+		if l.bucketsHeld(buckets) {
+			continue
 		}
-	}
-
-	log.Info("Rolling restart finished")
-
-	l.RollRestarting = false
-}
 
-// Starts the next cluster in the instance list if possible
-func (l *InstanceList) StartNext() {
-	// We are starting a new instance, so we are not fully up yet
-	l.FullyUp = false
+		var wait time.Duration
+		for _, b := range buckets {
+			l.bucketHolders[b] = i.ClusterID
 
-	// Get next instance to start
-	for _, i := range l.Instances {
-		if i.Command == nil || i.Command.Process == nil {
-			if l.Config.ClusterStartNextDelay == nil {
-				l.Config.ClusterStartNextDelay = utils.Pointer(5)
+			if d := time.Until(l.bucketFreeAt[b]); d > wait {
+				wait = d
 			}
+		}
 
-			snd := *l.Config.ClusterStartNextDelay
-			log.Info("Going to start *next* cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") after delay of "+strconv.Itoa(snd)+" seconds due to concurrency")
-			time.Sleep(time.Second * time.Duration(snd))
-			err := l.Start(i)
+		i.queued = true
 
-			if err != nil {
-				log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") start failure: ", err)
-				go l.ActionLog(map[string]any{
-					"event": "cluster_start_failed",
-					"via":   "start_next",
-				})
-			}
+		go l.launch(i, wait)
+	}
 
-			i.Unlock() // Unlock cluster after starting
-			return
-		}
+	if pending || len(l.bucketHolders) > 0 {
+		return
 	}
 
 	log.Info("No more instances to start. All done!!!")
 	l.SendMessage(utils.RandomString(16), "", "bot", "all_clusters_launched")
-	l.FullyUp = true // If we get here, we are fully up
+	l.setFullyUp(true) // If we get here, we are fully up
 }
 
-// Kills all clusters in the instance list
+// Stops all clusters in the instance list, all clusters are stopped concurrently using the same graceful stop sequence as Stop
 func (l *InstanceList) KillAll() {
-	// Kill all instances
-	for _, i := range l.Instances {
-		if i.Command == nil || i.Command.Process == nil {
+	var wg sync.WaitGroup
+
+	for _, i := range l.List() {
+		if p, _ := i.current(); p == nil {
 			log.Error("Cluster " + l.Cluster(i).Name + " (" + strconv.Itoa(l.Cluster(i).ID) + ") is not running")
-			i.Active = false
-		} else {
-			log.Info("Killing cluster " + l.Cluster(i).Name + " (" + strconv.Itoa(l.Cluster(i).ID) + ")")
+			continue
+		}
 
-			i.AcquireLockAndLock(l, "KillAll")
+		log.Info("Killing cluster " + l.Cluster(i).Name + " (" + strconv.Itoa(l.Cluster(i).ID) + ")")
 
-			i.Command.Process.Kill()
-			i.Active = false
-			i.SessionID = ""
-		}
+		wg.Add(1)
+		go func(i *Instance) {
+			defer wg.Done()
+
+			i.AcquireLockAndLock(l, "KillAll")
+			l.Stop(i)
+			i.Unlock()
+		}(i)
 	}
 
 	// Wait for all instances to die
-	for _, i := range l.Instances {
-		if i.Command == nil {
-			continue
-		}
-		i.Command.Wait()
-
-		i.Unlock()
-	}
+	wg.Wait()
 }
 
 // Returns the ClusterMap for a specific instance
 func (l *InstanceList) Cluster(i *Instance) *ClusterMap {
-	for _, c := range l.Map {
-		if c.ID == i.ClusterID {
-			return &c
-		}
+	return l.ClusterByID(i.ClusterID)
+}
+
+// Returns the ClusterMap with the given cluster ID (including clusters removed by Reshard), or nil if there is none
+func (l *InstanceList) ClusterByID(id int) *ClusterMap {
+	l.mu.RLock()
+
+	if !l.indexStale() {
+		defer l.mu.RUnlock()
+		return l.clusterByID(id)
 	}
-	return nil
+
+	l.mu.RUnlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.index()
+
+	return l.clusterByID(id)
+}
+
+func (l *InstanceList) clusterByID(id int) *ClusterMap {
+	if cm, ok := l.clustersByID[id]; ok {
+		return cm
+	}
+
+	return l.removed[id]
 }
 
 // Returns a Instance given its cluster ID
 func (l *InstanceList) InstanceByID(id int) *Instance {
-	for _, c := range l.Instances {
-		if c.ClusterID == id {
-			return c
+	l.mu.RLock()
+
+	if !l.indexStale() {
+		defer l.mu.RUnlock()
+		return l.instancesByID[id]
+	}
+
+	l.mu.RUnlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.index()
+
+	return l.instancesByID[id]
+}
+
+// Returns true if the indexes no longer match Instances or Map, which happens when they are set without SetInstances (such as when embedding mewld)
+func (l *InstanceList) indexStale() bool {
+	if l.instancesByID == nil || len(l.instancesByID) != len(l.Instances) || len(l.clustersByID) != len(l.Map) {
+		return true
+	}
+
+	// Catch slices which were replaced by slices of the same length
+	if len(l.Instances) > 0 && l.instancesByID[l.Instances[0].ClusterID] != l.Instances[0] {
+		return true
+	}
+
+	return len(l.Map) > 0 && l.clustersByID[l.Map[0].ID] != &l.Map[0]
+}
+
+// Rebuilds the indexes of the instance list, the mutex of the instance list must be held
+func (l *InstanceList) index() {
+	l.instancesByID = make(map[int]*Instance, len(l.Instances))
+	for _, i := range l.Instances {
+		l.instancesByID[i.ClusterID] = i
+	}
+
+	l.clustersByID = make(map[int]*ClusterMap, len(l.Map))
+	for idx := range l.Map {
+		l.clustersByID[l.Map[idx].ID] = &l.Map[idx]
+	}
+}
+
+// Replaces the cluster map and instances of the instance list
+func (l *InstanceList) SetInstances(clusterMap []ClusterMap, instances []*Instance) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ids := map[int]bool{}
+	for _, cm := range clusterMap {
+		ids[cm.ID] = true
+		delete(l.removed, cm.ID)
+	}
+
+	for idx := range l.Map {
+		if ids[l.Map[idx].ID] {
+			continue
+		}
+
+		if l.removed == nil {
+			l.removed = map[int]*ClusterMap{}
 		}
+
+		l.removed[l.Map[idx].ID] = &l.Map[idx]
 	}
-	return nil
+
+	l.Map = clusterMap
+	l.Instances = instances
+	l.index()
+}
+
+// Returns the instances of the instance list, the returned slice may be modified by the caller
+func (l *InstanceList) List() []*Instance {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	instances := make([]*Instance, len(l.Instances))
+	copy(instances, l.Instances)
+
+	return instances
 }
 
 type StopCode int
@@ -604,67 +859,270 @@ const (
 	StopCodeRestartFailed StopCode = -1
 )
 
-// Attempts to stop a instance returning a status code defining whether the cluster could be stopped or not
+// How a cluster exited when it was stopped, recorded in the “cluster_stopped“ action log
+const (
+	StopExitClean         = "clean"          // The cluster exited by itself after prepare_shutdown/SIGTERM
+	StopExitForceKilled   = "force_killed"   // The cluster did not exit within the grace period and was sent SIGKILL
+	StopExitAlreadyExited = "already_exited" // The cluster had already exited before it was stopped
+)
+
+// Attempts to stop a instance returning a status code defining whether the cluster could be stopped or not, the caller must hold the lock of the instance
+//
+// A cluster is first sent a “prepare_shutdown“ message which it should acknowledge, then SIGTERM and finally
+// SIGKILL if it has not exited after “stop_grace_period“ seconds
 func (l *InstanceList) Stop(i *Instance) StopCode {
-	if i.Command == nil || i.Command.Process == nil {
+	return l.stop(i, StateStopped, "stop")
+}
+
+// Stops an instance, moving it to the given state (stopped or crashed) once its process has exited. Cordoned instances stay cordoned
+func (l *InstanceList) stop(i *Instance, final LifecycleState, reason string) StopCode {
+	if p, _ := i.current(); p == nil {
 		log.Error("Cluster " + l.Cluster(i).Name + " (" + strconv.Itoa(l.Cluster(i).ID) + ") is not running. Cannot stop process which isn't running?")
+
+		i.mu.Lock()
 		i.SessionID = "" // Just in case, we set session ID to empty string, this kills observer
+		i.mu.Unlock()
+
 		return StopCodeRestartFailed
 	}
 
 	log.Info("Stopping cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
 
-	i.Lock(l, "Stop", false)
+	// Only uncordon removes a cordon, so a stopped cordoned cluster is still left alone
+	if i.Status() == StateCordoned {
+		final = StateCordoned
+	}
+
+	// Stopped and crashed clusters have already exited
+	if i.Running() {
+		l.Transition(i, StateStopping, reason)
+	}
+
+	i.mu.Lock()
+	sid := i.SessionID
+	i.SessionID = "" // Set before signalling the cluster so the observer does not restart it
+	i.mu.Unlock()
 
-	i.Command.Process.Kill()
+	exitType := l.gracefulStop(i, sid)
 
-	i.Active = false
+	l.removeCgroup(i)
 
-	i.SessionID = ""
+	l.Transition(i, final, reason)
 
-	i.Unlock()
+	log.Info("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") stopped (", exitType, ")")
 
-	log.Info("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") stopped")
+	l.ActionLog(map[string]any{
+		"event": "cluster_stopped",
+		"id":    i.ClusterID,
+		"exit":  exitType,
+	})
 
 	return StopCodeNormal
 }
 
-// Starts a instance in the instance list, this locks the cluster if not already locked before unlocking after startup
+// Runs the prepare_shutdown -> SIGTERM -> SIGKILL sequence on the process of a cluster with the given session ID, returning how the cluster exited
+func (l *InstanceList) gracefulStop(i *Instance, sid string) string {
+	p, exit := i.current()
+
+	if exit == nil {
+		// Nothing is waiting on the process, so there is no way to know when it exits
+		p.Signal(syscall.SIGKILL)
+		return StopExitForceKilled
+	}
+
+	select {
+	case <-exit.done:
+		return StopExitAlreadyExited
+	default:
+	}
+
+	if !l.PrepareShutdown(i, sid) {
+		log.Warn("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") did not acknowledge prepare_shutdown, sending SIGTERM anyways")
+	}
+
+	return l.terminate(i, p, exit)
+}
+
+// Sends SIGTERM to a process of a cluster, followed by SIGKILL if it has not exited within “stop_grace_period“, returning how it exited
+func (l *InstanceList) terminate(i *Instance, p Process, exit *processExit) string {
+	if exit == nil {
+		p.Signal(syscall.SIGKILL)
+		return StopExitForceKilled
+	}
+
+	select {
+	case <-exit.done:
+		return StopExitAlreadyExited
+	default:
+	}
+
+	err := p.Signal(syscall.SIGTERM)
+
+	if err != nil {
+		log.Error("Could not send SIGTERM to cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, "): ", err)
+	}
+
+	grace := time.NewTimer(time.Second * time.Duration(*l.Config.StopGracePeriod))
+	defer grace.Stop()
+
+	select {
+	case <-exit.done:
+		return StopExitClean
+	case <-grace.C:
+	}
+
+	log.Warn("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") did not exit within the grace period, sending SIGKILL")
+
+	p.Signal(syscall.SIGKILL)
+
+	select {
+	case <-exit.done:
+	case <-time.After(time.Second * 10):
+		log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") did not exit after SIGKILL")
+	}
+
+	return StopExitForceKilled
+}
+
+// Sends a “prepare_shutdown“ message to the process of a cluster with the given session ID and waits for it to be acknowledged, returns false on timeout
+//
+// The session ID is sent so a standby process of the cluster (see Replace) can ignore the message
+func (l *InstanceList) PrepareShutdown(i *Instance, sid string) bool {
+	ack := make(chan struct{}, 1)
+
+	i.mu.Lock()
+	i.shutdownAck = ack
+	i.mu.Unlock()
+
+	defer func() {
+		i.mu.Lock()
+		i.shutdownAck = nil
+		i.mu.Unlock()
+	}()
+
+	err := l.SendMessage(utils.RandomString(16), map[string]any{"id": i.ClusterID, "session_id": sid}, "bot", "prepare_shutdown")
+
+	if err != nil {
+		log.Error("Could not send prepare_shutdown to cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, "): ", err)
+		return false
+	}
+
+	timeout := time.NewTimer(time.Second * time.Duration(*l.Config.ShutdownAckTimeout))
+	defer timeout.Stop()
+
+	select {
+	case <-ack:
+		return true
+	case <-timeout.C:
+		return false
+	}
+}
+
+// Marks a pending prepare_shutdown for a cluster as acknowledged
+func (l *InstanceList) AcknowledgeShutdown(id int) {
+	i := l.InstanceByID(id)
+
+	var ack chan struct{}
+	if i != nil {
+		i.mu.RLock()
+		ack = i.shutdownAck
+		i.mu.RUnlock()
+	}
+
+	if ack == nil {
+		log.Warn("Got prepare_shutdown acknowledgement for cluster ", id, " which is not shutting down")
+		return
+	}
+
+	select {
+	case ack <- struct{}{}:
+	default:
+	}
+}
+
+// Starts a instance in the instance list, the caller must hold the lock of the instance
 func (l *InstanceList) Start(i *Instance) error {
 	// Mutex to prevent multiple instances from starting at the same time
 	l.StartMutex.Lock()
 	defer l.StartMutex.Unlock()
 
-	if !i.Locked() {
-		i.Lock(l, "Start", false)
+	if i.Status() == StateCordoned {
+		return fmt.Errorf("cluster %d is cordoned", i.ClusterID)
 	}
 
-	i.StartedAt = time.Now()
-	l.LastClusterStartedAt = time.Now()
-	i.SessionID = utils.RandomString(32)
-	i.LastChecked = time.Now()
-
-	log.Info("Starting cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") in directory ", l.Dir)
-
 	cluster := l.Cluster(i)
 
 	if cluster == nil {
 		return errors.New("cluster not found")
 	}
 
-	err := l.LoaderData.Start(l, i, cluster)
+	err := l.Transition(i, StateStarting, "start")
 
-	i.Unlock()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	l.LastClusterStartedAt = now
+	l.mu.Unlock()
+
+	i.mu.Lock()
+	i.StartedAt = now
+	i.SessionID = utils.RandomString(32)
+	i.LastChecked = now
+	i.LaunchedFully = false
+	i.LaunchTimedOut = false
+
+	// Resource usage of the previous process
+	i.Metrics = nil
+	i.MetricsHistory = nil
+
+	// Start functions set either Process or (for local processes) Command
+	i.Command = nil
+	i.Process = nil
+	i.mu.Unlock()
+
+	log.Info("Starting cluster ", cluster.Name, " (", cluster.ID, ") in directory ", l.Dir)
+
+	err = l.LoaderData.Start(l, i, cluster)
+
+	i.mu.Lock()
+
+	if err == nil && i.Process == nil {
+		if i.Command == nil || i.Command.Process == nil {
+			err = errors.New("start function did not start a process")
+		} else {
+			i.Process = LocalProcess(i.Command)
+		}
+	}
+
+	if err == nil {
+		i.exit = watchExit(i.Process)
+	}
+
+	sid := i.SessionID
+	shards := len(i.Shards)
+
+	i.mu.Unlock()
 
 	if err != nil {
+		l.Transition(i, StateCrashed, "start_failed")
 		return fmt.Errorf("cluster %d failed to start: %w", i.ClusterID, err)
 	}
 
-	i.Active = true
+	l.SpendSessions(uint64(shards))
+
+	l.Transition(i, StateLaunching, "start")
+
+	go l.Observe(i, sid)
 
-	go l.Observe(i, i.SessionID)
+	go l.PingCheck(i, sid)
 
-	go l.PingCheck(i, i.SessionID)
+	go l.MetricsCheck(i, sid)
+
+	go l.LaunchCheck(i, sid)
 
 	return nil
 }
@@ -678,21 +1136,21 @@ func (l *InstanceList) PingCheck(i *Instance, sid string) {
 	for {
 		select {
 		case <-ticker.C:
-			if i.SessionID == "" || sid != i.SessionID {
+			if current := i.Session(); current == "" || sid != current {
 				log.Info("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is no longer eligible for ping checks from this goroutine")
 				return // Stop observer if instance is stopped
 			}
 
 			log.Info("Pinging cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") [automated ping check] at time: ", time.Now())
-			if !i.Active {
-				log.Info("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is not active. Stopping ping check.")
-				PingCheckStop <- i.ClusterID
+			if !i.Running() {
+				log.Info("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is not running (", i.Status(), "). Stopping ping check.")
+				l.pingCheckStopChannel() <- i.ClusterID
 				return
 			}
 
-			if i.Command == nil {
+			if p, _ := i.current(); p == nil {
 				log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is not running. Stopping ping check.")
-				PingCheckStop <- i.ClusterID
+				l.pingCheckStopChannel() <- i.ClusterID
 				return
 			}
 
@@ -708,43 +1166,37 @@ func (l *InstanceList) PingCheck(i *Instance, sid string) {
 					"id":    i.ClusterID,
 				})
 
-				if i.Locked() {
-					// Oops, we have a locked observer
-					log.Error("Cluster locked, cannot restart ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
+				if i.Status() == StateCordoned {
+					log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is not responding but is cordoned, not restarting it")
 					continue
 				}
 
-				log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is not responding. Restarting.")
+				l.Transition(i, StateUnhealthy, "ping_failure")
 
-				i.Lock(l, "PingCheck", false)
+				log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is not responding. Restarting.")
 
 				currentlyKilling = true
-				time.Sleep(time.Second * 1)
-				l.Stop(i)
-				time.Sleep(time.Second * 3)
-				err = l.Start(i)
-
-				if err != nil {
-					log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") start failure: ", err)
-					go l.ActionLog(map[string]any{
-						"event": "cluster_start_failed",
-						"via":   "ping_check",
-					})
-				}
-
+				_, err = l.autoRestart(i, sid, "PingCheck", "ping_check")
 				currentlyKilling = false
 
-				i.Unlock()
+				if err == ErrLockedInstance {
+					continue
+				}
 
 				return
 			}
 
 			if err != nil {
 				log.Error("Ping error on cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, "): ", err)
+				continue
 			}
 
-			i.ClusterHealth = clusterHealth
-		case c := <-PingCheckStop:
+			i.SetHealth(clusterHealth)
+
+			if i.Launched() && i.Status() == StateUnhealthy {
+				l.Transition(i, StateReady, "ping_check")
+			}
+		case c := <-l.pingCheckStopChannel():
 			if currentlyKilling {
 				// Currently killing, don't stop
 				continue
@@ -758,48 +1210,101 @@ func (l *InstanceList) PingCheck(i *Instance, sid string) {
 	}
 }
 
-// Observes a cluster and restarts it if necessary (unexpected death of the cluster)
-func (l *InstanceList) Observe(i *Instance, sid string) {
-	if err := i.Command.Wait(); err != nil {
-		if i.SessionID == "" || sid != i.SessionID {
-			return // Stop observer if instance is stopped
+// Why a cluster exited, recorded in the “cluster_died“ action log
+const (
+	ExitReasonOOMKilled = "oom_killed" // The cluster was killed by the kernel OOM killer inside its cgroup
+	ExitReasonSignal    = "signal"     // The cluster was killed by a signal
+	ExitReasonExitCode  = "exit_code"  // The cluster exited with a non-zero exit code
+	ExitReasonUnknown   = "unknown"
+)
+
+// Returns why the process of an instance exited given the error returned when waiting on it
+func (l *InstanceList) ExitReason(i *Instance, err error) string {
+	i.mu.RLock()
+	cgroup, startOOMKills := i.Cgroup, i.oomKills
+	i.mu.RUnlock()
+
+	if cgroup != "" {
+		if oomKills, oomErr := cgroupOOMKills(cgroup); oomErr == nil && oomKills > startOOMKills {
+			return ExitReasonOOMKilled
 		}
+	}
 
-		if i.Locked() {
-			log.Error("Cluster locked, cannot restart ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
-			return
+	if exiterr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return ExitReasonSignal
 		}
 
-		if l.RollRestarting {
-			log.Error("Roll restart is in progress, ignoring restart on cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
-			return
+		return ExitReasonExitCode
+	}
+
+	if rerr, ok := err.(*RemoteExitError); ok {
+		if rerr.Signaled {
+			return ExitReasonSignal
+		}
+
+		if rerr.ExitCode >= 0 {
+			return ExitReasonExitCode
 		}
+	}
+
+	return ExitReasonUnknown
+}
+
+// Observes a cluster and restarts it if necessary (unexpected death of the cluster)
+func (l *InstanceList) Observe(i *Instance, sid string) {
+	_, exit := i.current()
+
+	<-exit.done
+
+	if current := i.Session(); current == "" || sid != current {
+		return // Stop observer if instance is stopped
+	}
 
-		i.Active = false
-		i.Lock(l, "Observe", true)
+	err := exit.err
 
-		log.Error("Cluster "+l.Cluster(i).Name+" ("+strconv.Itoa(l.Cluster(i).ID)+") died unexpectedly: ", err)
+	if err == nil {
+		log.Info("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") exited by itself")
 
-		if exiterr, ok := err.(*exec.ExitError); ok {
-			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-				log.Infof("Exit Status: %d", status.ExitStatus())
+		if i.Lock(l, "Observe") == nil {
+			if sid == i.Session() {
+				l.stop(i, StateStopped, "exited")
 			}
+
+			i.Unlock()
 		}
 
-		// Restart process
-		time.Sleep(time.Second * 3)
-		l.Stop(i)
-		time.Sleep(time.Second * 3)
-		err = l.Start(i)
+		return
+	}
+
+	reason := l.ExitReason(i, err)
 
-		if err != nil {
-			log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") start failure: ", err)
-			go l.ActionLog(map[string]any{
-				"event": "cluster_start_failed",
-				"via":   "observe",
-			})
+	log.Error("Cluster "+l.Cluster(i).Name+" ("+strconv.Itoa(l.Cluster(i).ID)+") died unexpectedly ("+reason+"): ", err)
+
+	if exiterr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+			log.Infof("Exit Status: %d", status.ExitStatus())
 		}
+	}
+
+	go l.ActionLog(map[string]any{
+		"event":  "cluster_died",
+		"id":     i.ClusterID,
+		"reason": reason,
+		"error":  err.Error(),
+	})
 
-		i.Unlock()
+	if i.Status() == StateCordoned {
+		log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is cordoned, not restarting it")
+		return
 	}
+
+	l.Transition(i, StateCrashed, reason)
+
+	if l.IsRollRestarting() {
+		log.Error("Roll restart is in progress, ignoring restart on cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
+		return
+	}
+
+	l.autoRestart(i, sid, "Observe", "observe")
 }
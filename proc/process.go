@@ -0,0 +1,110 @@
+package proc
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// A running process of a cluster, either a local process or a process on an agent
+type Process interface {
+	Pid() int                          // PID of the process on the host it runs on
+	Signal(sig syscall.Signal) error   // Sends a signal to the process
+	Wait() error                       // Waits for the process to exit, may only be called once
+	Metrics() (*ProcessMetrics, error) // Samples the resource usage of the process
+}
+
+type localProcess struct {
+	cmd *exec.Cmd
+}
+
+// Returns a Process for a started local command
+func LocalProcess(cmd *exec.Cmd) Process {
+	return &localProcess{cmd: cmd}
+}
+
+func (p *localProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+func (p *localProcess) Signal(sig syscall.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+func (p *localProcess) Wait() error {
+	return p.cmd.Wait()
+}
+
+func (p *localProcess) Metrics() (*ProcessMetrics, error) {
+	return SampleProcess(p.cmd.Process.Pid)
+}
+
+// Error returned by Wait when a process on an agent exits unsuccessfully (or contact with the agent is lost)
+type RemoteExitError struct {
+	ExitCode int    `json:"exit_code"` // Exit code of the process, -1 if it was killed by a signal or its status is unknown
+	Signaled bool   `json:"signaled"`  // Whether or not the process was killed by a signal
+	Message  string `json:"message"`
+}
+
+func (e *RemoteExitError) Error() string {
+	return e.Message
+}
+
+// Converts the error returned by waiting on a local command into a RemoteExitError, returns nil if err is nil
+func NewRemoteExitError(err error) *RemoteExitError {
+	if err == nil {
+		return nil
+	}
+
+	rerr := &RemoteExitError{
+		ExitCode: -1,
+		Message:  err.Error(),
+	}
+
+	if exiterr, ok := err.(*exec.ExitError); ok {
+		rerr.ExitCode = exiterr.ExitCode()
+
+		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			rerr.Signaled = true
+		}
+	}
+
+	return rerr
+}
+
+// A process on this host which was not started by this mewld instance (see Adopt), identified by its PID
+type pidProcess struct {
+	pid int
+}
+
+func newPidProcess(pid int) *pidProcess {
+	return &pidProcess{pid: pid}
+}
+
+func (p *pidProcess) Pid() int {
+	return p.pid
+}
+
+func (p *pidProcess) Signal(sig syscall.Signal) error {
+	return syscall.Kill(p.pid, sig)
+}
+
+// Polls the process until it no longer exists, the exit status of a process which is not a child is unknown
+func (p *pidProcess) Wait() error {
+	for pidAlive(p.pid) {
+		time.Sleep(time.Second)
+	}
+
+	return &RemoteExitError{ExitCode: -1, Message: "adopted process exited"}
+}
+
+func (p *pidProcess) Metrics() (*ProcessMetrics, error) {
+	return SampleProcess(p.pid)
+}
+
+// Returns whether or not a process with the given PID exists
+func pidAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
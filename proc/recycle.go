@@ -0,0 +1,131 @@
+package proc
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Returns why an instance should be recycled, or an empty string if it should not be
+func (l *InstanceList) recycleReason(i *Instance) string {
+	rc := l.Config.Recycle
+
+	if i.Status() != StateReady {
+		return ""
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if i.CrashLooping {
+		return ""
+	}
+
+	if rc.MaxRSS > 0 && i.Metrics != nil && i.Metrics.RSS > rc.MaxRSS*1024*1024 {
+		return "max_rss"
+	}
+
+	if rc.MaxUptime > 0 && time.Since(i.StartedAt) > time.Minute*time.Duration(rc.MaxUptime) {
+		return "max_uptime"
+	}
+
+	return ""
+}
+
+// Recycles clusters which exceed the limits set in “recycle“, should be called as a seperate goroutine
+func (l *InstanceList) RecycleCheck() {
+	rc := &l.Config.Recycle
+
+	if rc.MaxRSS == 0 && rc.MaxUptime == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second * time.Duration(*rc.CheckInterval))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !l.IsFullyUp() || l.IsRollRestarting() {
+			continue
+		}
+
+		for _, i := range l.List() {
+			reason := l.recycleReason(i)
+
+			if reason == "" {
+				continue
+			}
+
+			l.Recycle(i, reason)
+		}
+	}
+}
+
+// Restarts a cluster due to a recycle policy, waiting for it to send launch_next. Only one cluster is recycled at a time
+func (l *InstanceList) Recycle(i *Instance, reason string) {
+	l.recycleMutex.Lock()
+	defer l.recycleMutex.Unlock()
+
+	if ok, _ := l.CanSpendSessions(uint64(len(i.ShardList()))); !ok {
+		log.Error("Not enough session starts remaining to recycle cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
+		return
+	}
+
+	log.Info("Recycling cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") due to ", reason)
+
+	i.mu.RLock()
+
+	payload := map[string]any{
+		"event":  "auto_recycle",
+		"id":     i.ClusterID,
+		"reason": reason,
+		"uptime": time.Since(i.StartedAt).Seconds(),
+	}
+
+	if i.Metrics != nil {
+		payload["rss"] = i.Metrics.RSS
+	}
+
+	i.mu.RUnlock()
+
+	go l.ActionLog(payload)
+
+	i.AcquireLockAndLock(l, "AutoRecycle")
+
+	if i.Status() != StateReady {
+		log.Info("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is ", i.Status(), ", not recycling it")
+		i.Unlock()
+		return
+	}
+
+	code := l.Stop(i)
+
+	if code == StopCodeRestartFailed {
+		log.Error("Recycle failed on cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
+		i.Unlock()
+		return
+	}
+
+	err := l.Start(i)
+
+	i.Unlock()
+
+	if err != nil {
+		log.Error("Recycle failed on cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, "): ", err)
+		go l.ActionLog(map[string]any{
+			"event": "cluster_restart_failed",
+			"via":   "auto_recycle",
+		})
+		return
+	}
+
+	// Wait for the cluster to come back up before recycling anything else
+	deadline := time.Now().Add(time.Second * time.Duration(*l.Config.Recycle.LaunchTimeout))
+	for !i.Launched() {
+		if time.Now().After(deadline) {
+			log.Error("Recycled cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") did not send launch_next in time")
+			return
+		}
+
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,233 @@
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/cheesycod/mewld/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A standby process started by Replace
+type standby struct {
+	instance *Instance     // The standby process, not part of the instance list
+	ready    chan struct{} // Signalled when the standby sends standby_ready
+}
+
+// Returns true if the instance is a standby process started by Replace, Start functions use this to start the cluster in standby mode
+func (i *Instance) IsStandby() bool {
+	return i.isStandby
+}
+
+// Marks the standby process of a cluster as ready to take over, called when it sends “standby_ready“
+func (l *InstanceList) StandbyReady(id int, sid string) {
+	i := l.InstanceByID(id)
+
+	var sb *standby
+	if i != nil {
+		i.mu.RLock()
+		sb = i.standby
+		i.mu.RUnlock()
+	}
+
+	if sb == nil || sb.instance.Session() != sid {
+		log.Warn("Got standby_ready for cluster ", id, " which has no standby with session ID ", sid)
+		return
+	}
+
+	select {
+	case sb.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Replaces the process of a cluster with a new one without taking its shards offline (blue/green), the caller should lock the cluster
+//
+// A standby process is started for the same shards (with “MEWLD_STANDBY=1“ set when using DefaultStart) alongside the old one.
+// Once the standby sends “standby_ready“, the old process is sent “prepare_shutdown“ and, as soon as it acknowledges it (or
+// “shutdown_ack_timeout“ passes), the standby is sent a “promote“ message and takes over the cluster. The old process is
+// then terminated (SIGTERM, then SIGKILL after “stop_grace_period“) while the standby is already serving its shards. If the standby does not become ready within “standby_timeout“ seconds, it is killed and the
+// old process is left running
+func (l *InstanceList) Replace(i *Instance) error {
+	if _, exit := i.current(); !i.Running() || exit == nil {
+		return fmt.Errorf("cluster %d is not running", i.ClusterID)
+	}
+
+	cluster := l.Cluster(i)
+
+	if cluster == nil {
+		return errors.New("cluster not found")
+	}
+
+	shards := i.ShardList()
+
+	if ok, resetAt := l.CanSpendSessions(uint64(len(shards))); !ok {
+		return fmt.Errorf("not enough session starts remaining to replace cluster %d until %s", i.ClusterID, resetAt)
+	}
+
+	next := &Instance{
+		InstanceInfo: InstanceInfo{
+			StartedAt: time.Now(),
+			SessionID: utils.RandomString(32),
+			ClusterID: i.ClusterID,
+			Shards:    shards,
+			Host:      i.host(), // Prefer starting the standby on the same host
+		},
+		Logs:      l.ClusterLogBuffer(i),
+		logFile:   l.clusterLogFile(i),
+		isStandby: true,
+	}
+
+	sb := &standby{
+		instance: next,
+		ready:    make(chan struct{}, 1),
+	}
+
+	i.mu.Lock()
+
+	if i.standby != nil {
+		i.mu.Unlock()
+		return fmt.Errorf("cluster %d is already being replaced", i.ClusterID)
+	}
+
+	i.standby = sb
+	i.Replacing = true
+
+	i.mu.Unlock()
+
+	defer func() {
+		i.mu.Lock()
+		i.standby = nil
+		i.Replacing = false
+		i.mu.Unlock()
+	}()
+
+	log.Info("Starting standby process for cluster ", cluster.Name, " (", cluster.ID, ")")
+
+	go l.ActionLog(map[string]any{
+		"event": "cluster_replace_begin",
+		"id":    i.ClusterID,
+	})
+
+	err := l.startStandby(i, sb, cluster)
+
+	if err != nil {
+		log.Error("Could not replace cluster ", cluster.Name, " (", cluster.ID, "): ", err)
+
+		go l.ActionLog(map[string]any{
+			"event": "cluster_replace_failed",
+			"id":    i.ClusterID,
+			"error": err.Error(),
+		})
+
+		return err
+	}
+
+	next.mu.RLock()
+	sid := next.SessionID
+	command, process, host, exit := next.Command, next.Process, next.Host, next.exit
+	cgroup, oomKills, startedAt := next.Cgroup, next.oomKills, next.StartedAt
+	next.mu.RUnlock()
+
+	// Cut over in one step, changing the session ID also stops the observer and checks of the old process
+	i.mu.Lock()
+	oldSid, oldProcess, oldExit := i.SessionID, i.Process, i.exit
+	i.SessionID = sid
+	i.Command = command
+	i.Process = process
+	i.Host = host
+	i.exit = exit
+	i.Cgroup = cgroup
+	i.oomKills = oomKills
+	i.StartedAt = startedAt
+	i.LastChecked = time.Now()
+	i.LaunchedFully = true
+	i.LaunchTimedOut = false
+	i.Metrics = nil
+	i.MetricsHistory = nil
+	i.mu.Unlock()
+
+	// The old process has disconnected from the gateway once it acknowledges prepare_shutdown, so the standby can take over
+	// right away instead of after the old process has exited
+	if !l.PrepareShutdown(i, oldSid) {
+		log.Warn("Cluster ", cluster.Name, " (", cluster.ID, ") did not acknowledge prepare_shutdown, promoting the standby anyways")
+	}
+
+	if i.Status() != StateCordoned {
+		l.Transition(i, StateReady, "replace")
+	}
+
+	err = l.SendMessage(utils.RandomString(16), map[string]any{"id": i.ClusterID, "session_id": sid}, "bot", "promote")
+
+	if err != nil {
+		log.Error("Could not send promote to cluster ", cluster.Name, " (", cluster.ID, "): ", err)
+	}
+
+	go l.Observe(i, sid)
+
+	go l.PingCheck(i, sid)
+
+	go l.MetricsCheck(i, sid)
+
+	exitType := l.terminate(i, oldProcess, oldExit)
+
+	log.Info("Cluster ", cluster.Name, " (", cluster.ID, ") replaced, old process stopped (", exitType, ")")
+
+	go l.ActionLog(map[string]any{
+		"event":    "cluster_replaced",
+		"id":       i.ClusterID,
+		"old_exit": exitType,
+	})
+
+	return nil
+}
+
+// Starts the standby process of a cluster and waits for it to send standby_ready
+func (l *InstanceList) startStandby(i *Instance, sb *standby, cluster *ClusterMap) error {
+	next := sb.instance
+
+	l.StartMutex.Lock()
+	err := l.LoaderData.Start(l, next, cluster)
+	l.StartMutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("standby for cluster %d failed to start: %w", i.ClusterID, err)
+	}
+
+	next.mu.Lock()
+
+	if next.Process == nil {
+		if next.Command == nil || next.Command.Process == nil {
+			next.mu.Unlock()
+			return fmt.Errorf("standby for cluster %d failed to start: start function did not start a process", i.ClusterID)
+		}
+
+		next.Process = LocalProcess(next.Command)
+	}
+
+	next.exit = watchExit(next.Process)
+	p, exit := next.Process, next.exit
+
+	next.mu.Unlock()
+
+	l.SpendSessions(uint64(len(next.Shards)))
+
+	timeout := time.NewTimer(time.Second * time.Duration(*l.Config.StandbyTimeout))
+	defer timeout.Stop()
+
+	select {
+	case <-sb.ready:
+		return nil
+	case <-exit.done:
+		return fmt.Errorf("standby for cluster %d exited before becoming ready: %v", i.ClusterID, exit.err)
+	case <-timeout.C:
+	}
+
+	p.Signal(syscall.SIGKILL)
+	<-exit.done
+
+	return fmt.Errorf("standby for cluster %d did not become ready within %d seconds", i.ClusterID, *l.Config.StandbyTimeout)
+}
@@ -0,0 +1,97 @@
+package proc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplaceCutsOverBeforeTerminating(t *testing.T) {
+	l := newTestInstanceList(t, 1)
+	i := l.List()[0]
+
+	// The old process ignores SIGTERM, so Replace keeps waiting on it until it exits
+	old, standbyProcess := newFakeProcess(), newFakeProcess()
+	old.stubborn = true
+
+	procs := []*fakeProcess{old, standbyProcess}
+	l.LoaderData.Start = func(l *InstanceList, i *Instance, cm *ClusterMap) error {
+		i.mu.Lock()
+		i.Process = procs[0]
+		i.mu.Unlock()
+
+		procs = procs[1:]
+		return nil
+	}
+
+	i.AcquireLockAndLock(l, "test")
+	defer i.Unlock()
+
+	if err := l.Start(i); err != nil {
+		t.Fatal(err)
+	}
+
+	l.MarkLaunched(i)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- l.Replace(i)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	var sb *standby
+	for sb == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("standby was not started")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+
+		i.mu.RLock()
+		sb = i.standby
+		i.mu.RUnlock()
+	}
+
+	sid := sb.instance.Session()
+	l.StandbyReady(i.ClusterID, sid)
+
+	// While the old process is being terminated, the cluster already runs the standby and can be read
+	for i.Session() != sid {
+		if time.Now().After(deadline) {
+			t.Fatal("standby did not take over")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if p, _ := i.current(); p != standbyProcess {
+		t.Error("cluster switched to the session of the standby before its process")
+	}
+
+	select {
+	case err := <-errs:
+		t.Fatalf("replace returned before the old process exited: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if info := i.Snapshot(); info.State != StateReady || !info.Replacing {
+		t.Errorf("got state %s (replacing %v) while terminating the old process", info.State, info.Replacing)
+	}
+
+	old.exit()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("replace did not return once the old process exited")
+	}
+
+	if info := i.Snapshot(); info.Replacing {
+		t.Error("cluster is still replacing")
+	}
+
+	l.Stop(i)
+}
@@ -0,0 +1,199 @@
+package proc
+
+import (
+	"sort"
+
+	"github.com/cheesycod/mewld/utils"
+)
+
+// How a cluster is changed by a reshard
+const (
+	ClusterUnchanged = "unchanged" // The cluster keeps its shards and is left running
+	ClusterExpanded  = "expanded"  // The cluster is restarted with its new shards (or its old ones with “reshard_all“)
+	ClusterCreated   = "created"   // The cluster is created and started
+	ClusterRemoved   = "removed"   // The cluster is stopped and removed
+)
+
+// What a reshard would do to a cluster
+type ClusterPlan struct {
+	ID            int      `json:"id"`
+	Name          string   `json:"name"`
+	Change        string   `json:"change"`
+	OldShards     []uint64 `json:"old_shards"`
+	NewShards     []uint64 `json:"new_shards"`
+	SessionStarts uint64   `json:"session_starts"`
+	Downtime      float64  `json:"downtime"` // Estimated seconds from the start of the reshard until all shards of the cluster have identified again
+}
+
+// A shard which changes cluster, From is nil for new shards and To is nil for shards which no longer exist
+type ShardMove struct {
+	Shard uint64 `json:"shard"`
+	From  *int   `json:"from"`
+	To    *int   `json:"to"`
+}
+
+// What a reshard would do if started now, see PlanReshard
+type ReshardPlan struct {
+	OldShardCount     uint64        `json:"old_shard_count"`
+	NewShardCount     uint64        `json:"new_shard_count"`
+	MaxConcurrency    uint64        `json:"max_concurrency"`
+	Clusters          []ClusterPlan `json:"clusters"`
+	ShardMoves        []ShardMove   `json:"shard_moves"`
+	SessionStarts     uint64        `json:"session_starts"`     // Total sessions started by the reshard
+	SessionsRemaining uint64        `json:"sessions_remaining"` // Session starts remaining according to Get Gateway Bot
+	BudgetAllows      bool          `json:"budget_allows"`      // Whether the session starts can be spent while keeping “minimum_safe_sessions_remaining“
+	Downtime          float64       `json:"downtime"`           // Estimated seconds until every restarted shard has identified again
+}
+
+// Computes what Reshard would do using a fresh Get Gateway Bot response without changing any clusters
+//
+// Downtime is estimated from the identify rate limit. Reshard restarts every changed cluster at once, so all restarted
+// shards compete for the same buckets (“shard_id % max_concurrency“) and the shards of a bucket identify one after
+// another, IdentifyWindow apart, in the order the clusters are restarted. The time taken to stop clusters is not included
+func (l *InstanceList) PlanReshard() (*ReshardPlan, error) {
+	gb, clusterMap, err := l.reshardClusterMap()
+
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ReshardPlan{
+		OldShardCount:     l.TotalShards(),
+		NewShardCount:     gb.Shards,
+		MaxConcurrency:    gb.SessionStartLimit.MaxConcurrency,
+		SessionsRemaining: gb.SessionStartLimit.Remaining,
+	}
+
+	instances := l.List()
+
+	from := map[uint64]int{}
+	to := map[uint64]int{}
+
+	for idx, cMap := range clusterMap {
+		for _, shard := range cMap.Shards {
+			to[shard] = cMap.ID
+		}
+
+		if idx >= len(instances) {
+			plan.Clusters = append(plan.Clusters, planCluster(ClusterCreated, cMap.ID, cMap.Name, nil, cMap.Shards))
+			continue
+		}
+
+		oldShards := instances[idx].ShardList()
+
+		change := ClusterExpanded
+
+		if !l.Config.ReshardAll && utils.SlicesEqual(oldShards, cMap.Shards) {
+			change = ClusterUnchanged
+		}
+
+		plan.Clusters = append(plan.Clusters, planCluster(change, cMap.ID, cMap.Name, oldShards, cMap.Shards))
+	}
+
+	for idx, i := range instances {
+		for _, shard := range i.ShardList() {
+			from[shard] = i.ClusterID
+		}
+
+		if idx >= len(clusterMap) {
+			plan.Clusters = append(plan.Clusters, ClusterPlan{
+				ID:        i.ClusterID,
+				Name:      l.Cluster(i).Name,
+				Change:    ClusterRemoved,
+				OldShards: i.ShardList(),
+			})
+		}
+	}
+
+	for _, c := range plan.Clusters {
+		plan.SessionStarts += c.SessionStarts
+	}
+
+	plan.Downtime = estimateDowntime(plan.Clusters, plan.MaxConcurrency)
+
+	// Checked against the fresh Get Gateway Bot response, a plan is only a preview and must not change the session start budget
+	plan.BudgetAllows = gb.SessionStartLimit.Remaining >= plan.SessionStarts+l.minimumSafeSessions()
+
+	plan.ShardMoves = shardMoves(from, to)
+
+	return plan, nil
+}
+
+func planCluster(change string, id int, name string, oldShards, newShards []uint64) ClusterPlan {
+	c := ClusterPlan{
+		ID:        id,
+		Name:      name,
+		Change:    change,
+		OldShards: oldShards,
+		NewShards: newShards,
+	}
+
+	if change == ClusterExpanded || change == ClusterCreated {
+		c.SessionStarts = uint64(len(newShards))
+	}
+
+	return c
+}
+
+// Sets the estimated downtime of every restarted cluster (in restart order), returning the downtime of the whole reshard
+func estimateDowntime(clusters []ClusterPlan, maxConcurrency uint64) float64 {
+	if maxConcurrency == 0 {
+		maxConcurrency = 1
+	}
+
+	queued := map[uint64]int{} // Shards queued per bucket so far
+	var total float64
+
+	for idx := range clusters {
+		c := &clusters[idx]
+
+		if c.SessionStarts == 0 {
+			continue
+		}
+
+		for _, shard := range c.NewShards {
+			queued[shard%maxConcurrency]++
+
+			// The shard identifies once every shard queued before it in its bucket has, taking up the bucket for IdentifyWindow each
+			if identified := float64(queued[shard%maxConcurrency]) * IdentifyWindow.Seconds(); identified > c.Downtime {
+				c.Downtime = identified
+			}
+		}
+
+		if c.Downtime > total {
+			total = c.Downtime
+		}
+	}
+
+	return total
+}
+
+// Returns the shards whose cluster differs between from and to (shard -> cluster ID), ordered by shard
+func shardMoves(from, to map[uint64]int) []ShardMove {
+	moves := []ShardMove{}
+
+	for shard, f := range from {
+		f := f
+		t, ok := to[shard]
+
+		if !ok {
+			moves = append(moves, ShardMove{Shard: shard, From: &f})
+		} else if t != f {
+			moves = append(moves, ShardMove{Shard: shard, From: &f, To: &t})
+		}
+	}
+
+	for shard, t := range to {
+		t := t
+
+		if _, ok := from[shard]; !ok {
+			moves = append(moves, ShardMove{Shard: shard, To: &t})
+		}
+	}
+
+	sort.Slice(moves, func(a, b int) bool {
+		return moves[a].Shard < moves[b].Shard
+	})
+
+	return moves
+}
@@ -0,0 +1,185 @@
+package proc
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Records an automatic restart of an instance and returns how long to wait before restarting it
+//
+// Returns false if the instance is crash looping, in which case it must not be restarted automatically
+func (l *InstanceList) nextRestart(i *Instance, via string) (time.Duration, bool) {
+	i.mu.RLock()
+	crashLooping := i.CrashLooping
+	i.mu.RUnlock()
+
+	if crashLooping {
+		log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is crash looping, not restarting automatically")
+		return 0, false
+	}
+
+	rp := &l.Config.RestartPolicy
+
+	now := time.Now()
+	window := time.Second * time.Duration(*rp.Window)
+
+	i.mu.Lock()
+
+	// Only restarts within the window count towards crash loop detection
+	history := []time.Time{}
+	for _, t := range i.RestartHistory {
+		if now.Sub(t) < window {
+			history = append(history, t)
+		}
+	}
+
+	if len(history) >= *rp.MaxRestarts {
+		i.RestartHistory = history
+		i.CrashLooping = true
+		i.mu.Unlock()
+
+		log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") restarted ", len(history), " times in ", window, ", disabling automatic restarts")
+
+		go l.ActionLog(map[string]any{
+			"event":    "crash_loop_detected",
+			"id":       i.ClusterID,
+			"restarts": len(history),
+			"via":      via,
+		})
+
+		return 0, false
+	}
+
+	history = append(history, now)
+	i.RestartHistory = history
+	i.mu.Unlock()
+
+	// Exponential backoff, doubling the delay for every restart in the window
+	delay := time.Second * time.Duration(*rp.BaseDelay)
+	maxDelay := time.Second * time.Duration(*rp.MaxDelay)
+	for n := 1; n < len(history) && delay < maxDelay; n++ {
+		delay *= 2
+	}
+
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	// Add up to 20% jitter so clusters dying together do not restart together
+	delay += time.Duration(rand.Int63n(int64(delay)/5 + 1))
+
+	log.Info("Restarting cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") in ", delay, " (restart ", len(history), " in window)")
+
+	return delay, true
+}
+
+// Waits before automatically restarting an instance, returning false if it must not be restarted
+//
+// This applies the backoff and crash loop detection of “restart_policy“ and defers the restart while the session start budget is too low
+func (l *InstanceList) awaitAutoRestart(i *Instance, via string) bool {
+	delay, ok := l.nextRestart(i, via)
+
+	if !ok {
+		return false
+	}
+
+	time.Sleep(delay)
+
+	return l.awaitSessionBudget(i, via)
+}
+
+// Stops a failed instance and starts it again following “restart_policy“, returning true if it was started again
+//
+// The lock of the instance is only held while stopping and starting it, so it can still be stopped, started or cordoned
+// while waiting to restart it. ErrLockedInstance is returned if another operation holds the lock
+func (l *InstanceList) autoRestart(i *Instance, sid string, subsystem string, via string) (bool, error) {
+	if err := i.Lock(l, subsystem); err != nil {
+		return false, err
+	}
+
+	if sid != i.Session() {
+		// Stopped or restarted by another operation in the meantime
+		i.Unlock()
+		return false, nil
+	}
+
+	l.stop(i, StateCrashed, via)
+
+	i.Unlock()
+
+	if !l.awaitAutoRestart(i, via) {
+		return false, nil
+	}
+
+	i.AcquireLockAndLock(l, subsystem)
+	defer i.Unlock()
+
+	if i.Status() != StateCrashed {
+		log.Info("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is ", i.Status(), ", no longer restarting it")
+		return i.Running(), nil
+	}
+
+	err := l.Start(i)
+
+	if err != nil {
+		log.Error("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") start failure: ", err)
+		go l.ActionLog(map[string]any{
+			"event": "cluster_start_failed",
+			"via":   via,
+		})
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Clears the crash looping state of an instance, re-enabling automatic restarts
+func (l *InstanceList) ClearCrashLoop(i *Instance, via string) {
+	i.mu.Lock()
+	crashLooping := i.CrashLooping
+	i.RestartHistory = nil
+	i.CrashLooping = false
+	i.mu.Unlock()
+
+	if !crashLooping {
+		return
+	}
+
+	log.Info("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is no longer marked as crash looping")
+
+	go l.ActionLog(map[string]any{
+		"event": "crash_loop_cleared",
+		"id":    i.ClusterID,
+		"via":   via,
+	})
+}
+
+// Restarts a cluster, locking it while it is being restarted
+func (l *InstanceList) Restart(i *Instance, subsystem string) error {
+	if err := i.Lock(l, subsystem); err != nil {
+		return err
+	}
+
+	defer i.Unlock()
+
+	if i.Status() == StateCordoned {
+		return fmt.Errorf("cluster %d is cordoned", i.ClusterID)
+	}
+
+	if l.Config.ZeroDowntimeRestarts && i.Running() {
+		return l.Replace(i)
+	}
+
+	if p, _ := i.current(); p != nil {
+		code := l.Stop(i)
+
+		if code != StopCodeNormal {
+			return fmt.Errorf("cluster %d could not be stopped (stop code %d)", i.ClusterID, code)
+		}
+	}
+
+	return l.Start(i)
+}
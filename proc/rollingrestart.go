@@ -0,0 +1,386 @@
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Progress of a rolling restart
+type RollingRestartProgress struct {
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	Batches     [][]int    `json:"batches"`      // Cluster IDs of every batch
+	Batch       int        `json:"batch"`        // Index of the batch currently being restarted
+	Done        []int      `json:"done"`         // Cluster IDs which have been restarted and passed the health gate
+	Paused      bool       `json:"paused"`       // Whether or not the rolling restart was paused because a batch failed the health gate
+	PauseReason string     `json:"pause_reason"` // Why the rolling restart was paused
+}
+
+// Begins a rolling restart, should be called as a seperate goroutine
+//
+// Clusters are restarted in batches of “rolling_restart.batch_size“. Once every cluster in a batch has sent launch_next,
+// the batch must pass a health gate (all shards up with a latency under “rolling_restart.max_latency“) within
+// “rolling_restart.health_timeout“ seconds, otherwise the rolling restart is paused. Returns an error if the rolling
+// restart could not be started
+func (l *InstanceList) RollingRestart() error {
+	if !l.IsFullyUp() {
+		return errors.New("not fully up, not rolling restarting")
+	}
+
+	batchSize := *l.Config.RollingRestart.BatchSize
+
+	progress := &RollingRestartProgress{
+		StartedAt: time.Now(),
+		Done:      []int{},
+	}
+
+	for idx, i := range l.List() {
+		if idx%batchSize == 0 {
+			progress.Batches = append(progress.Batches, []int{})
+		}
+
+		progress.Batches[len(progress.Batches)-1] = append(progress.Batches[len(progress.Batches)-1], i.ClusterID)
+	}
+
+	l.mu.Lock()
+
+	if l.RollRestarting {
+		l.mu.Unlock()
+		return errors.New("rolling restart already in progress")
+	}
+
+	l.RollRestarting = true
+	l.RollingRestartProgress = progress
+
+	l.mu.Unlock()
+
+	go l.ActionLog(map[string]any{
+		"event":   "rolling_restart",
+		"batches": progress.Batches,
+	})
+
+	l.runRollingRestart(progress)
+
+	return nil
+}
+
+// Resumes a paused rolling restart from the batch that failed the health gate, should be called as a seperate goroutine
+func (l *InstanceList) ResumeRollingRestart() error {
+	l.mu.Lock()
+
+	progress := l.RollingRestartProgress
+
+	if progress == nil || !progress.Paused {
+		l.mu.Unlock()
+		return errors.New("no paused rolling restart")
+	}
+
+	if l.RollRestarting {
+		l.mu.Unlock()
+		return errors.New("rolling restart already in progress")
+	}
+
+	l.RollRestarting = true
+	progress.Paused = false
+	progress.PauseReason = ""
+
+	l.mu.Unlock()
+
+	go l.ActionLog(map[string]any{
+		"event": "rolling_restart_resumed",
+		"batch": progress.Batch,
+	})
+
+	l.runRollingRestart(progress)
+
+	return nil
+}
+
+// Tells a rolling restart waiting on a batch that a cluster sent launch_next or was skipped by LaunchCheck, this is a
+// no-op if no rolling restart is waiting on a batch
+func (l *InstanceList) NotifyRollRestart(id int) {
+	l.mu.RLock()
+	notify := l.launchWaiters
+	l.mu.RUnlock()
+
+	if notify == nil {
+		return
+	}
+
+	select {
+	case notify <- id:
+	default:
+		// The buffer is full of other notifications, the launch of the cluster is still seen by polling
+	}
+}
+
+// RollRestarting must already be set, it is cleared once the rolling restart finishes or is paused
+func (l *InstanceList) runRollingRestart(progress *RollingRestartProgress) {
+	defer func() {
+		l.mu.Lock()
+		l.RollRestarting = false
+		l.mu.Unlock()
+	}()
+
+	for progress.Batch < len(progress.Batches) {
+		batch := progress.Batches[progress.Batch]
+
+		log.Info("Rolling restart on batch ", progress.Batch+1, "/", len(progress.Batches), ": ", batch)
+
+		go l.ActionLog(map[string]any{
+			"event":    "rolling_restart_batch",
+			"batch":    progress.Batch,
+			"clusters": batch,
+		})
+
+		restarted, err := l.restartBatch(batch)
+
+		if err == nil {
+			err = l.awaitBatchHealthy(restarted)
+		}
+
+		if err != nil {
+			log.Error("Rolling restart paused on batch ", progress.Batch+1, ": ", err)
+
+			l.mu.Lock()
+			progress.Paused = true
+			progress.PauseReason = err.Error()
+			l.mu.Unlock()
+
+			go l.ActionLog(map[string]any{
+				"event":  "rolling_restart_paused",
+				"batch":  progress.Batch,
+				"reason": err.Error(),
+			})
+
+			return
+		}
+
+		l.mu.Lock()
+		progress.Done = append(progress.Done, batch...)
+		progress.Batch++
+		l.mu.Unlock()
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	progress.FinishedAt = &now
+	l.mu.Unlock()
+
+	log.Info("Rolling restart finished")
+
+	go l.ActionLog(map[string]any{
+		"event": "rolling_restart_finished",
+	})
+}
+
+// Restarts (or replaces, with “zero_downtime_restarts“) all clusters in a batch and waits for restarted clusters to send launch_next, returning the clusters which were restarted
+func (l *InstanceList) restartBatch(batch []int) ([]*Instance, error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	restarted := []*Instance{}
+	launching := []*Instance{} // Clusters which were stopped and started, and so will send launch_next
+	failed := []string{}
+
+	// Installed before the batch is started as clusters may send launch_next before the whole batch has been started. Clusters
+	// outside of the batch may also send launch_next, so there is room for a notification from every cluster
+	notify := make(chan int, len(l.List()))
+
+	l.mu.Lock()
+	l.launchWaiters = notify
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		l.launchWaiters = nil
+		l.mu.Unlock()
+	}()
+
+	for _, id := range batch {
+		i := l.InstanceByID(id)
+
+		if i == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i *Instance) {
+			defer wg.Done()
+
+			log.Info("Rolling restart on cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
+
+			i.AcquireLockAndLock(l, "RollingRestart")
+			defer i.Unlock()
+
+			if i.Status() == StateCordoned {
+				log.Info("Cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") is cordoned, skipping it")
+				return
+			}
+
+			if l.Config.ZeroDowntimeRestarts && i.Running() {
+				err := l.Replace(i)
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				if err != nil {
+					failed = append(failed, fmt.Sprintf("cluster %d could not be replaced: %s", i.ClusterID, err))
+					return
+				}
+
+				restarted = append(restarted, i)
+				return
+			}
+
+			code := l.Stop(i)
+
+			if code == StopCodeRestartFailed {
+				log.Error("Rolling restart failed on cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, "), skipping it")
+				return
+			}
+
+			// Now start cluster again
+			err := l.Start(i)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				log.Error("Rolling restart failed on cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ")")
+				go l.ActionLog(map[string]any{
+					"event": "cluster_restart_failed",
+					"via":   "rolling_restart",
+				})
+				failed = append(failed, fmt.Sprintf("cluster %d failed to start: %s", i.ClusterID, err))
+				return
+			}
+
+			restarted = append(restarted, i)
+			launching = append(launching, i)
+		}(i)
+	}
+
+	wg.Wait()
+
+	waiting := map[int]*Instance{}
+	for _, i := range launching {
+		waiting[i.ClusterID] = i
+	}
+
+	deadline := time.After(time.Second * time.Duration(*l.Config.RollingRestart.LaunchTimeout))
+
+	// Notifications only wake the loop up, whether a cluster has launched is always read from the cluster itself (as
+	// launch_next may still be received from a process of the cluster which was stopped). Launches are also polled in
+	// case a notification was dropped
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+wait:
+	for len(waiting) > 0 {
+		select {
+		case id := <-notify:
+			i, ok := waiting[id]
+
+			if !ok {
+				log.Info("Ignoring launch of cluster ", id, " as it is not part of the batch")
+				continue
+			}
+
+			// Clusters which were skipped by LaunchCheck are only done once it has notified the rolling restart
+			if launched, timedOut := i.launchStatus(); launched || timedOut {
+				delete(waiting, id)
+			}
+		case <-ticker.C:
+			for id, i := range waiting {
+				if i.Launched() {
+					delete(waiting, id)
+				}
+			}
+		case <-deadline:
+			ids := make([]int, 0, len(waiting))
+			for id := range waiting {
+				ids = append(ids, id)
+			}
+
+			sort.Ints(ids)
+
+			failed = append(failed, fmt.Sprintf("clusters %v did not send launch_next within %d seconds", ids, *l.Config.RollingRestart.LaunchTimeout))
+			break wait
+		}
+	}
+
+	if len(failed) > 0 {
+		return restarted, errors.New(strings.Join(failed, ", "))
+	}
+
+	return restarted, nil
+}
+
+// Waits for all shards of the instances to be up (with a latency under “rolling_restart.max_latency“), returning an error on timeout
+func (l *InstanceList) awaitBatchHealthy(batch []*Instance) error {
+	rc := &l.Config.RollingRestart
+
+	deadline := time.Now().Add(time.Second * time.Duration(*rc.HealthTimeout))
+
+	for {
+		unhealthy := []string{}
+
+		for _, i := range batch {
+			if reason := l.unhealthyReason(i, *rc.MaxLatency); reason != "" {
+				unhealthy = append(unhealthy, reason)
+			}
+		}
+
+		if len(unhealthy) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("batch did not become healthy in time: %s", strings.Join(unhealthy, ", "))
+		}
+
+		log.Info("Waiting for batch to become healthy: ", strings.Join(unhealthy, ", "))
+
+		time.Sleep(time.Second * time.Duration(*rc.HealthInterval))
+	}
+}
+
+// Scans the shards of an instance, returning why it is unhealthy or an empty string if it is healthy
+func (l *InstanceList) unhealthyReason(i *Instance, maxLatency float64) string {
+	health, err := l.ScanShards(i)
+
+	if err != nil {
+		return fmt.Sprintf("cluster %d could not be scanned: %s", i.ClusterID, err)
+	}
+
+	i.SetHealth(health)
+
+	up := map[uint64]bool{}
+	for _, shard := range health {
+		if !shard.Up {
+			continue
+		}
+
+		if maxLatency > 0 && shard.Latency > maxLatency {
+			return fmt.Sprintf("cluster %d shard %d has a latency of %v", i.ClusterID, shard.ShardID, shard.Latency)
+		}
+
+		up[shard.ShardID] = true
+	}
+
+	for _, shard := range i.ShardList() {
+		if !up[shard] {
+			return fmt.Sprintf("cluster %d shard %d is not up", i.ClusterID, shard)
+		}
+	}
+
+	return ""
+}
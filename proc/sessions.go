@@ -0,0 +1,126 @@
+package proc
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Running session start budget, decremented for every shard started and periodically re-synced from Get Gateway Bot
+type SessionBudget struct {
+	Total      uint64    `json:"total"`       // Total number of session starts allowed per reset
+	Remaining  uint64    `json:"remaining"`   // Estimated number of session starts remaining
+	ResetAt    time.Time `json:"reset_at"`    // Time at which the budget resets to Total
+	LastSynced time.Time `json:"last_synced"` // The last time the budget was synced from Get Gateway Bot
+}
+
+// Returns the minimum number of sessions that should always remain, “minimum_safe_sessions_remaining“
+func (l *InstanceList) minimumSafeSessions() uint64 {
+	return *l.Config.MinimumSafeSessionsRemaining
+}
+
+// Resets the budget if its reset time has passed, sessionMutex must be held
+func (l *InstanceList) refreshSessionBudget() {
+	if l.SessionBudget.ResetAt.IsZero() || time.Now().Before(l.SessionBudget.ResetAt) {
+		return
+	}
+
+	// Discord resets the limit once per day, the next sync will correct the reset time
+	l.SessionBudget.Remaining = l.SessionBudget.Total
+	l.SessionBudget.ResetAt = time.Now().Add(24 * time.Hour)
+}
+
+// Syncs the session start budget with the session start limit of a Get Gateway Bot response
+func (l *InstanceList) SyncSessionBudget(gb *GatewayBot) {
+	l.sessionMutex.Lock()
+	defer l.sessionMutex.Unlock()
+
+	l.SessionBudget = SessionBudget{
+		Total:      gb.SessionStartLimit.Total,
+		Remaining:  gb.SessionStartLimit.Remaining,
+		ResetAt:    time.Now().Add(time.Millisecond * time.Duration(gb.SessionStartLimit.ResetAfter)),
+		LastSynced: time.Now(),
+	}
+}
+
+// Removes n sessions from the session start budget
+func (l *InstanceList) SpendSessions(n uint64) {
+	l.sessionMutex.Lock()
+	defer l.sessionMutex.Unlock()
+
+	l.refreshSessionBudget()
+
+	if n > l.SessionBudget.Remaining {
+		l.SessionBudget.Remaining = 0
+	} else {
+		l.SessionBudget.Remaining -= n
+	}
+}
+
+// Returns whether n sessions can be started without going below “minimum_safe_sessions_remaining“ and when the budget resets
+func (l *InstanceList) CanSpendSessions(n uint64) (bool, time.Time) {
+	mssr := l.minimumSafeSessions()
+
+	l.sessionMutex.Lock()
+	defer l.sessionMutex.Unlock()
+
+	if l.SessionBudget.LastSynced.IsZero() {
+		return true, time.Time{} // The budget is not being tracked
+	}
+
+	l.refreshSessionBudget()
+
+	return l.SessionBudget.Remaining >= n+mssr, l.SessionBudget.ResetAt
+}
+
+// Defers an automatic restart of an instance until the session start budget allows it
+//
+// Returns false if the instance was started by something else while waiting
+func (l *InstanceList) awaitSessionBudget(i *Instance, via string) bool {
+	sid := i.Session()
+	n := uint64(len(i.ShardList()))
+
+	ok, resetAt := l.CanSpendSessions(n)
+
+	if ok {
+		return true
+	}
+
+	log.Error("Not enough session starts remaining to restart cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, "), deferring restart until ", resetAt)
+
+	go l.ActionLog(map[string]any{
+		"event":    "restart_deferred",
+		"id":       i.ClusterID,
+		"via":      via,
+		"reset_at": resetAt.UnixMicro(),
+	})
+
+	for !ok {
+		time.Sleep(time.Until(resetAt) + time.Second)
+
+		if i.Session() != sid {
+			return false
+		}
+
+		ok, resetAt = l.CanSpendSessions(n)
+	}
+
+	return true
+}
+
+// Re-syncs the session start budget from Get Gateway Bot every “budget_sync_interval“ seconds, should be called as a seperate goroutine
+func (l *InstanceList) SessionBudgetCheck() {
+	ticker := time.NewTicker(time.Second * time.Duration(*l.Config.SessionBudgetSyncInterval))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		gb, err := GetGatewayBot(l.Config)
+
+		if err != nil {
+			log.Error("Could not sync session start budget: ", err)
+			continue
+		}
+
+		l.SyncSessionBudget(gb)
+	}
+}
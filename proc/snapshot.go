@@ -0,0 +1,181 @@
+package proc
+
+import "time"
+
+// Immutable copy of the state of an instance list, returned by Snapshot
+type InstanceListSnapshot struct {
+	LastClusterStartedAt   time.Time               `json:"LastClusterStartedAt"`
+	Map                    []ClusterMap            `json:"Map"`
+	Instances              []InstanceInfo          `json:"Instances"`
+	ShardCount             uint64                  `json:"ShardCount"`
+	GatewayBot             GatewayBot              `json:"GetGatewayBot"`
+	Dir                    string                  `json:"Dir"`
+	RollRestarting         bool                    `json:"RollRestarting"`
+	FullyUp                bool                    `json:"FullyUp"`
+	Leader                 bool                    `json:"Leader"`
+	SessionBudget          SessionBudget           `json:"SessionBudget"`
+	RollingRestartProgress *RollingRestartProgress `json:"RollingRestartProgress"`
+}
+
+// Returns a copy of the state of the instance list and its instances which is safe to read (and serialize) while mewld keeps running
+func (l *InstanceList) Snapshot() InstanceListSnapshot {
+	l.sessionMutex.Lock()
+	budget := l.SessionBudget
+	l.sessionMutex.Unlock()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	snap := InstanceListSnapshot{
+		LastClusterStartedAt: l.LastClusterStartedAt,
+		Map:                  make([]ClusterMap, len(l.Map)),
+		Instances:            make([]InstanceInfo, 0, len(l.Instances)),
+		ShardCount:           l.ShardCount,
+		GatewayBot:           l.GatewayBot,
+		Dir:                  l.Dir,
+		RollRestarting:       l.RollRestarting,
+		FullyUp:              l.FullyUp,
+		Leader:               l.Leader,
+		SessionBudget:        budget,
+	}
+
+	for idx, cm := range l.Map {
+		cm.Shards = append([]uint64(nil), cm.Shards...)
+		snap.Map[idx] = cm
+	}
+
+	for _, i := range l.Instances {
+		snap.Instances = append(snap.Instances, i.Snapshot())
+	}
+
+	if p := l.RollingRestartProgress; p != nil {
+		progress := *p
+		progress.Batches = make([][]int, len(p.Batches))
+		for idx, batch := range p.Batches {
+			progress.Batches[idx] = append([]int(nil), batch...)
+		}
+		progress.Done = append([]int(nil), p.Done...)
+		snap.RollingRestartProgress = &progress
+	}
+
+	return snap
+}
+
+// Returns a copy of the information about an instance
+func (i *Instance) Snapshot() InstanceInfo {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	info := i.InstanceInfo
+	info.Shards = append([]uint64(nil), i.Shards...)
+	info.ClusterHealth = append([]ShardHealth(nil), i.ClusterHealth...)
+	info.RestartHistory = append([]time.Time(nil), i.RestartHistory...)
+	info.MetricsHistory = append([]ProcessMetrics(nil), i.MetricsHistory...)
+
+	if i.Metrics != nil {
+		metrics := *i.Metrics
+		info.Metrics = &metrics
+	}
+
+	if info.State == "" {
+		info.State = StateStopped
+	}
+
+	return info
+}
+
+// Returns the session ID of the current process of the instance
+func (i *Instance) Session() string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.SessionID
+}
+
+// Returns a copy of the shards of the instance
+func (i *Instance) ShardList() []uint64 {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return append([]uint64(nil), i.Shards...)
+}
+
+// Returns true if the current process of the instance has sent launch_next
+func (i *Instance) Launched() bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.LaunchedFully
+}
+
+// Returns a copy of the cached shard health of the instance
+func (i *Instance) Health() []ShardHealth {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return append([]ShardHealth(nil), i.ClusterHealth...)
+}
+
+// Sets the cached shard health of the instance
+func (i *Instance) SetHealth(health []ShardHealth) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.ClusterHealth = health
+	i.LastChecked = time.Now()
+}
+
+// Returns the current process of the instance and its exit tracker
+func (i *Instance) current() (Process, *processExit) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.Process, i.exit
+}
+
+// Returns the host the instance runs on
+func (i *Instance) host() string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.Host
+}
+
+// Returns whether the current process of the instance has sent launch_next and whether it did not do so within launch_timeout
+func (i *Instance) launchStatus() (launched bool, timedOut bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.LaunchedFully, i.LaunchTimedOut
+}
+
+// Returns true if all clusters have been launched
+func (l *InstanceList) IsFullyUp() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.FullyUp
+}
+
+func (l *InstanceList) setFullyUp(fullyUp bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.FullyUp = fullyUp
+}
+
+// Returns true if a rolling restart is in progress
+func (l *InstanceList) IsRollRestarting() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.RollRestarting
+}
+
+// Returns the total number of shards
+func (l *InstanceList) TotalShards() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.ShardCount
+}
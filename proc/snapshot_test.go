@@ -0,0 +1,220 @@
+package proc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/cheesycod/mewld/config"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// An ipc backend which drops everything written to it, except prepare_shutdown which is acknowledged at once
+type testIpc struct {
+	l *InstanceList
+}
+
+func (c *testIpc) Connect() error                                { return nil }
+func (c *testIpc) Disconnect() error                             { return nil }
+func (c *testIpc) Read() chan []byte                             { return make(chan []byte) }
+func (c *testIpc) GetKey(key string) ([]byte, error)             { return nil, nil }
+func (c *testIpc) StoreKey(key string, value []byte) error       { return nil }
+func (c *testIpc) GetKey_Array(key string) ([][]byte, error)     { return nil, nil }
+func (c *testIpc) StoreKey_Array(key string, value []byte) error { return nil }
+
+func (c *testIpc) Write(msg []byte) error {
+	var cmd struct {
+		Action string `json:"action"`
+		Output struct {
+			ID int `json:"id"`
+		} `json:"output"`
+	}
+
+	if json.Unmarshal(msg, &cmd) == nil && cmd.Action == "prepare_shutdown" {
+		c.l.AcknowledgeShutdown(cmd.Output.ID)
+	}
+
+	return nil
+}
+
+// A process which exits once it is sent SIGTERM or SIGKILL
+type fakeProcess struct {
+	once     sync.Once
+	done     chan struct{}
+	stubborn bool // Ignore SIGTERM and only exit on SIGKILL or exit
+}
+
+func newFakeProcess() *fakeProcess {
+	return &fakeProcess{done: make(chan struct{})}
+}
+
+func (p *fakeProcess) Pid() int { return 1 }
+
+func (p *fakeProcess) Signal(sig syscall.Signal) error {
+	if sig == syscall.SIGKILL || (sig == syscall.SIGTERM && !p.stubborn) {
+		p.exit()
+	}
+
+	return nil
+}
+
+func (p *fakeProcess) exit() {
+	p.once.Do(func() { close(p.done) })
+}
+
+func (p *fakeProcess) Wait() error {
+	<-p.done
+	return nil
+}
+
+func (p *fakeProcess) Metrics() (*ProcessMetrics, error) {
+	return &ProcessMetrics{}, nil
+}
+
+// Returns an instance list of the given number of clusters whose processes are fake, using the default config
+func newTestInstanceList(t *testing.T, clusters int) *InstanceList {
+	t.Helper()
+
+	// PingInterval has no default and must not be 0
+	cfg := &config.CoreConfig{PingInterval: 3600}
+	cfg.SetDefaults()
+
+	l := &InstanceList{
+		Ctx:    context.Background(),
+		Config: cfg,
+		LoaderData: &LoaderData{
+			Start: func(l *InstanceList, i *Instance, cm *ClusterMap) error {
+				i.mu.Lock()
+				i.Process = newFakeProcess()
+				i.mu.Unlock()
+				return nil
+			},
+		},
+	}
+
+	l.IPC = &testIpc{l: l}
+
+	clusterMap := GetClusterList(nil, uint64(clusters*2), 2)
+
+	instances := make([]*Instance, 0, len(clusterMap))
+	for _, cm := range clusterMap {
+		instances = append(instances, NewInstance(cm))
+	}
+
+	l.SetInstances(clusterMap, instances)
+
+	return l
+}
+
+func TestConcurrentLifecycleAndReads(t *testing.T) {
+	l := newTestInstanceList(t, 4)
+
+	// Writers run a fixed number of iterations, readers run until stop is closed
+	var writers, readers sync.WaitGroup
+	stop := make(chan struct{})
+
+	for _, i := range l.List() {
+		writers.Add(1)
+
+		go func(i *Instance) {
+			defer writers.Done()
+
+			for n := 0; n < 20; n++ {
+				i.AcquireLockAndLock(l, "test")
+
+				if err := l.Start(i); err != nil {
+					t.Errorf("start failed: %s", err)
+				}
+
+				l.MarkLaunched(i)
+				i.SetHealth([]ShardHealth{{ShardID: i.ShardList()[0], Up: true}})
+
+				if code := l.Stop(i); code != StopCodeNormal {
+					t.Errorf("stop failed with code %d", code)
+				}
+
+				i.Unlock()
+			}
+		}(i)
+
+		writers.Add(1)
+
+		go func(i *Instance) {
+			defer writers.Done()
+
+			// Most of these fail as the cluster is not running, which is fine
+			for n := 0; n < 100; n++ {
+				l.Transition(i, StateUnhealthy, "test")
+				l.Transition(i, StateReady, "test")
+			}
+		}(i)
+	}
+
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+
+		go func() {
+			defer readers.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				for _, info := range l.Snapshot().Instances {
+					if l.InstanceByID(info.ClusterID) == nil {
+						t.Errorf("cluster %d not found", info.ClusterID)
+					}
+				}
+
+				for _, i := range l.List() {
+					i.Snapshot()
+					i.Status()
+					i.Running()
+					i.Launched()
+					_ = l.Cluster(i).Name
+					_ = len(i.ShardList()) + len(i.Health())
+				}
+
+				l.IsFullyUp()
+				l.TotalShards()
+			}
+		}()
+	}
+
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+}
+
+func TestAccessorsReturnCopies(t *testing.T) {
+	l := newTestInstanceList(t, 1)
+	i := l.List()[0]
+
+	i.SetHealth([]ShardHealth{{ShardID: 0, Up: true}})
+
+	shards := i.ShardList()
+	shards[0] = 100
+
+	health := i.Health()
+	health[0].Up = false
+
+	if i.ShardList()[0] != 0 {
+		t.Errorf("modifying the result of ShardList changed the shards of the instance")
+	}
+
+	if !i.Health()[0].Up {
+		t.Errorf("modifying the result of Health changed the shard health of the instance")
+	}
+}
@@ -0,0 +1,337 @@
+package proc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheesycod/mewld/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Persisted state of an instance, used to adopt its process from another mewld instance
+type InstanceState struct {
+	ClusterID    int       `json:"cluster_id"`
+	SessionID    string    `json:"session_id"`
+	Shards       []uint64  `json:"shards"`
+	StartedAt    time.Time `json:"started_at"`
+	Pid          int       `json:"pid"`
+	ProcessStart uint64    `json:"process_start"` // Start time of the process in clock ticks since boot, used to detect reused PIDs
+	Host         string    `json:"host"`          // Agent the process runs on, empty if it runs on the host of the mewld instance which saved the state
+	Active       bool      `json:"active"`
+
+	State         LifecycleState `json:"state"`
+	LaunchedFully bool           `json:"launched_fully"`
+}
+
+// Persisted state of an instance list
+type State struct {
+	SavedAt    time.Time       `json:"saved_at"`
+	NodeID     string          `json:"node_id"`  // ha.node_id of the mewld instance which saved the state
+	Hostname   string          `json:"hostname"` // Hostname of the mewld instance which saved the state
+	ShardCount uint64          `json:"shard_count"`
+	FullyUp    bool            `json:"fully_up"`
+	Instances  []InstanceState `json:"instances"`
+}
+
+// Returns the current state of the instance list
+func (l *InstanceList) State() State {
+	hostname, _ := os.Hostname()
+
+	instances := l.List()
+
+	state := State{
+		SavedAt:    time.Now(),
+		NodeID:     l.NodeID(),
+		Hostname:   hostname,
+		ShardCount: l.TotalShards(),
+		FullyUp:    l.IsFullyUp(),
+		Instances:  make([]InstanceState, 0, len(instances)),
+	}
+
+	for _, i := range instances {
+		i.mu.RLock()
+		is := InstanceState{
+			ClusterID:     i.ClusterID,
+			SessionID:     i.SessionID,
+			Shards:        i.Shards,
+			StartedAt:     i.StartedAt,
+			Host:          i.Host,
+			LaunchedFully: i.LaunchedFully,
+		}
+		p := i.Process
+		i.mu.RUnlock()
+
+		is.Active = i.Running()
+		is.State = i.Status()
+
+		if p != nil {
+			is.Pid = p.Pid()
+
+			if is.Host == "" {
+				if st, err := readProcStat(is.Pid); err == nil {
+					is.ProcessStart = st.start
+				}
+			}
+		}
+
+		state.Instances = append(state.Instances, is)
+	}
+
+	return state
+}
+
+// Saves the state of the instance list to “state_file“, or the “state“ key of the ipc backend if it is not set
+func (l *InstanceList) SaveState() error {
+	b, err := json.Marshal(l.State())
+
+	if err != nil {
+		return err
+	}
+
+	if l.Config.StateFile == "" {
+		return l.IPC.StoreKey("state", b)
+	}
+
+	// Write to a temporary file first so a crash while saving does not leave a truncated state behind
+	tmp := l.Config.StateFile + ".tmp"
+
+	err = os.WriteFile(tmp, b, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, l.Config.StateFile)
+}
+
+// Loads the state saved by SaveState, returns nil if there is no saved state
+func (l *InstanceList) LoadState() *State {
+	var b []byte
+	var err error
+
+	if l.Config.StateFile == "" {
+		b, err = l.IPC.GetKey("state")
+	} else {
+		b, err = os.ReadFile(l.Config.StateFile)
+	}
+
+	if err != nil || len(b) == 0 {
+		log.Debug("No saved state: ", err)
+		return nil
+	}
+
+	var state State
+
+	err = json.Unmarshal(b, &state)
+
+	if err != nil {
+		log.Error("Could not parse saved state: ", err)
+		return nil
+	}
+
+	return &state
+}
+
+// Adopts the still running processes of a saved state, returning the number of adopted instances
+//
+// Processes on agents are adopted through their agent and processes on this host are observed by PID. Processes which
+// ran on another host are not adopted, their instances are cordoned instead so their shards are not started twice.
+// Instances whose shards no longer match the cluster map are not adopted. Every adopted instance must respond to a diag,
+// otherwise it is stopped (and left to be started again by StartNext)
+func (l *InstanceList) Adopt(state *State) int {
+	hostname, _ := os.Hostname()
+
+	adopted := []*Instance{}
+	for _, is := range state.Instances {
+		if !is.Active || is.Pid == 0 {
+			continue
+		}
+
+		i := l.InstanceByID(is.ClusterID)
+
+		if i == nil || !utils.SlicesEqual(i.ShardList(), is.Shards) {
+			log.Error("Cannot adopt cluster ", is.ClusterID, " (PID ", is.Pid, "), its shards no longer match the cluster map")
+			continue
+		}
+
+		if p, _ := i.current(); p != nil {
+			continue // Already running
+		}
+
+		var p Process
+		switch {
+		case is.Host != "":
+			p = &remoteProcess{
+				l:     l,
+				agent: is.Host,
+				sid:   is.SessionID,
+				pid:   is.Pid,
+				since: time.Now(),
+			}
+		case state.Hostname == hostname:
+			if !pidAlive(is.Pid) {
+				log.Info("Cluster ", is.ClusterID, " (PID ", is.Pid, ") is no longer running, not adopting it")
+				continue
+			}
+
+			if st, err := readProcStat(is.Pid); is.ProcessStart != 0 && (err != nil || st.start != is.ProcessStart) {
+				log.Info("PID ", is.Pid, " of cluster ", is.ClusterID, " now belongs to another process, not adopting it")
+				continue
+			}
+
+			p = newPidProcess(is.Pid)
+		default:
+			// The process can neither be signalled nor observed from here, and starting the cluster again could run its
+			// shards twice, so it is left cordoned until an operator has made sure the process is gone
+			log.Error("Cannot adopt cluster ", is.ClusterID, " (PID ", is.Pid, ") which ran on host ", state.Hostname, ", cordoning it")
+
+			go l.ActionLog(map[string]any{
+				"event": "cluster_not_adopted",
+				"id":    is.ClusterID,
+				"pid":   is.Pid,
+				"host":  state.Hostname,
+			})
+
+			l.Cordon(i)
+			continue
+		}
+
+		l.adopt(i, is, p)
+		adopted = append(adopted, i)
+	}
+
+	var wg sync.WaitGroup
+	var verified int64
+	for _, i := range adopted {
+		wg.Add(1)
+
+		go func(i *Instance) {
+			defer wg.Done()
+
+			if l.verifyAdopted(i) {
+				atomic.AddInt64(&verified, 1)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if verified > 0 && int(verified) == len(adopted) {
+		l.setFullyUp(state.FullyUp)
+	}
+
+	return int(verified)
+}
+
+// Checks that an adopted instance responds to a diag, stopping it if it does not
+func (l *InstanceList) verifyAdopted(i *Instance) bool {
+	var err error
+
+	// Retried once in case the IPC backend was still connecting
+	for attempt := 0; attempt < 2; attempt++ {
+		var health []ShardHealth
+		health, err = l.ScanShards(i)
+
+		if err == nil {
+			i.SetHealth(health)
+			return true
+		}
+	}
+
+	log.Error("Adopted cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") did not respond to diag, stopping it: ", err)
+
+	go l.ActionLog(map[string]any{
+		"event": "cluster_adoption_failed",
+		"id":    i.ClusterID,
+		"error": err.Error(),
+	})
+
+	i.AcquireLockAndLock(l, "Adopt")
+	l.Stop(i)
+	i.Unlock()
+
+	// Leave the instance for StartNext to start again
+	i.mu.Lock()
+	i.Process = nil
+	i.mu.Unlock()
+
+	return false
+}
+
+// Saves the state every “state_save_interval“ seconds while this instance is the leader, should be called as a seperate goroutine
+func (l *InstanceList) StateSaveCheck() {
+	ticker := time.NewTicker(time.Second * time.Duration(*l.Config.StateSaveInterval))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !l.IsLeader() {
+			continue
+		}
+
+		if err := l.SaveState(); err != nil {
+			log.Error("Could not save state: ", err)
+		}
+	}
+}
+
+// Adopts a single process, resuming observing and ping checks for it
+func (l *InstanceList) adopt(i *Instance, is InstanceState, p Process) {
+	log.Info("Adopting cluster ", l.Cluster(i).Name, " (", l.Cluster(i).ID, ") with PID ", is.Pid, hostSuffix(is.Host))
+
+	i.mu.Lock()
+	i.SessionID = is.SessionID
+	i.StartedAt = is.StartedAt
+	i.LastChecked = time.Now()
+	i.LaunchedFully = is.LaunchedFully
+	i.LaunchTimedOut = false
+	i.Host = is.Host
+	i.Command = nil
+	i.Process = p
+	i.exit = watchExit(p)
+	i.mu.Unlock()
+
+	l.Transition(i, StateStarting, "adopt")
+	l.Transition(i, StateLaunching, "adopt")
+
+	if is.LaunchedFully {
+		l.Transition(i, StateReady, "adopt")
+	}
+
+	if is.State == StateCordoned {
+		l.Transition(i, StateCordoned, "adopt")
+	}
+
+	go l.ActionLog(map[string]any{
+		"event": "cluster_adopted",
+		"id":    i.ClusterID,
+		"pid":   is.Pid,
+		"host":  is.Host,
+	})
+
+	go l.Observe(i, is.SessionID)
+
+	go l.PingCheck(i, is.SessionID)
+
+	go l.MetricsCheck(i, is.SessionID)
+
+	if !is.LaunchedFully {
+		go l.LaunchCheck(i, is.SessionID)
+	}
+
+	if rp, ok := p.(*remoteProcess); ok {
+		go l.followAgentLogs(i, rp)
+	}
+}
+
+func hostSuffix(host string) string {
+	if host == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" on agent %s", host)
+}
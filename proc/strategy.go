@@ -0,0 +1,277 @@
+package proc
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cheesycod/mewld/utils"
+)
+
+// Shard distribution strategies, see “shard_strategy“
+const (
+	ShardStrategyContiguous    = "contiguous"     // Blocks of “per_cluster“ consecutive shards (default)
+	ShardStrategyRoundRobin    = "round_robin"    // Shards are dealt to clusters one at a time
+	ShardStrategyBucketAligned = "bucket_aligned" // Blocks of complete identify buckets, so every cluster can identify its shards in parallel
+	ShardStrategyWeighted      = "weighted"       // Clusters are balanced by the guild counts of their shards
+)
+
+// Decides which shards each cluster runs
+type ShardStrategy interface {
+	// Returns the shards of every cluster in cluster ID order. Every shard below shards must be in exactly one cluster
+	// and no cluster may have more than perCluster shards (perCluster is at least 1). The only exception is
+	// BucketAlignedStrategy, which rounds perCluster up to max_concurrency when it is smaller
+	Distribute(shards uint64, perCluster uint64) [][]uint64
+}
+
+// Blocks of perCluster consecutive shards
+type ContiguousStrategy struct{}
+
+func (s ContiguousStrategy) Distribute(shards uint64, perCluster uint64) [][]uint64 {
+	var clusters [][]uint64
+
+	for i := uint64(0); i < shards; i += perCluster {
+		var block []uint64
+
+		for shard := i; shard < shards && shard < i+perCluster; shard++ {
+			block = append(block, shard)
+		}
+
+		clusters = append(clusters, block)
+	}
+
+	return clusters
+}
+
+// Shards are dealt to the same number of clusters as ContiguousStrategy one at a time, so cluster N runs every shard with “shard_id % clusters == N“
+type RoundRobinStrategy struct{}
+
+func (s RoundRobinStrategy) Distribute(shards uint64, perCluster uint64) [][]uint64 {
+	clusters := make([][]uint64, clusterCount(shards, perCluster))
+
+	for shard := uint64(0); shard < shards; shard++ {
+		c := shard % uint64(len(clusters))
+		clusters[c] = append(clusters[c], shard)
+	}
+
+	return clusters
+}
+
+// Blocks of consecutive shards whose size is a multiple of MaxConcurrency, so every cluster runs complete sets of
+// identify buckets (“shard_id % max_concurrency“). perCluster is rounded down to a multiple of MaxConcurrency, or up
+// to MaxConcurrency if it is smaller, so clusters can have more than perCluster shards
+type BucketAlignedStrategy struct {
+	MaxConcurrency uint64
+}
+
+func (s BucketAlignedStrategy) Distribute(shards uint64, perCluster uint64) [][]uint64 {
+	mc := s.MaxConcurrency
+
+	if mc == 0 {
+		mc = 1
+	}
+
+	size := perCluster / mc * mc
+
+	if size == 0 {
+		size = mc
+	}
+
+	return ContiguousStrategy{}.Distribute(shards, size)
+}
+
+// The same number of clusters as ContiguousStrategy, balanced by the guild counts (shard ID -> guilds) of their shards.
+// Shards without a guild count are assumed to have the average guild count. Without any guild counts, shards are
+// distributed like ContiguousStrategy
+type WeightedStrategy struct {
+	Guilds map[uint64]uint64
+}
+
+func (s WeightedStrategy) Distribute(shards uint64, perCluster uint64) [][]uint64 {
+	if len(s.Guilds) == 0 {
+		return ContiguousStrategy{}.Distribute(shards, perCluster)
+	}
+
+	var total uint64
+	for _, g := range s.Guilds {
+		total += g
+	}
+
+	avg := total / uint64(len(s.Guilds))
+
+	weights := make([]uint64, shards)
+	order := make([]uint64, shards)
+
+	for shard := uint64(0); shard < shards; shard++ {
+		order[shard] = shard
+
+		if g, ok := s.Guilds[shard]; ok {
+			weights[shard] = g
+		} else {
+			weights[shard] = avg
+		}
+	}
+
+	// Place the heaviest shards first, each on the least loaded cluster which still has room
+	sort.SliceStable(order, func(a, b int) bool {
+		return weights[order[a]] > weights[order[b]]
+	})
+
+	clusters := make([][]uint64, clusterCount(shards, perCluster))
+	load := make([]uint64, len(clusters))
+
+	for _, shard := range order {
+		best := -1
+
+		for c := range clusters {
+			if uint64(len(clusters[c])) >= perCluster {
+				continue
+			}
+
+			if best == -1 || load[c] < load[best] {
+				best = c
+			}
+		}
+
+		clusters[best] = append(clusters[best], shard)
+		load[best] += weights[shard]
+	}
+
+	for _, c := range clusters {
+		sort.Slice(c, func(a, b int) bool {
+			return c[a] < c[b]
+		})
+	}
+
+	return clusters
+}
+
+// Returns the number of clusters needed to run shards with at most perCluster shards per cluster
+func clusterCount(shards uint64, perCluster uint64) uint64 {
+	return (shards + perCluster - 1) / perCluster
+}
+
+// Returns the shard strategy with the given name (see “shard_strategy“), an empty name selects ShardStrategyContiguous
+func NewShardStrategy(name string, maxConcurrency uint64, guilds map[uint64]uint64) (ShardStrategy, error) {
+	switch name {
+	case "", ShardStrategyContiguous:
+		return ContiguousStrategy{}, nil
+	case ShardStrategyRoundRobin:
+		return RoundRobinStrategy{}, nil
+	case ShardStrategyBucketAligned:
+		return BucketAlignedStrategy{MaxConcurrency: maxConcurrency}, nil
+	case ShardStrategyWeighted:
+		return WeightedStrategy{Guilds: guilds}, nil
+	default:
+		return nil, fmt.Errorf("unknown shard_strategy %q", name)
+	}
+}
+
+// Builds a cluster map for the given number of shards using a shard strategy, naming clusters after clusterNames
+// (random names are used once clusterNames runs out)
+func GetClusterMap(clusterNames []string, shards uint64, perCluster uint64, strategy ShardStrategy) []ClusterMap {
+	if perCluster == 0 {
+		perCluster = 1
+	}
+
+	names := append([]string{}, clusterNames...)
+
+	var clusterMap []ClusterMap
+	for id, clusterShards := range strategy.Distribute(shards, perCluster) {
+		if id >= len(names) {
+			names = append(names, utils.RandomString(10))
+		}
+
+		clusterMap = append(clusterMap, ClusterMap{ID: id, Name: names[id], Shards: clusterShards})
+	}
+
+	return clusterMap
+}
+
+// Builds the cluster map for the shard count of gb using “shard_strategy“
+//
+// The weighted strategy uses the guild counts from the last shard health of every cluster, as long as the shard count
+// does not change (guilds are spread over different shards otherwise). Before any shard health is known (such as on
+// startup) and with “adopt_clusters“ or “ha.enabled“, the cluster layout of the saved state is kept if it has the same
+// shard count, so clusters distributed by guild counts can still be adopted
+func (l *InstanceList) BuildClusterMap(gb *GatewayBot) ([]ClusterMap, error) {
+	var guilds map[uint64]uint64
+
+	if gb.Shards == l.TotalShards() {
+		guilds = l.shardGuilds()
+	}
+
+	strategy, err := NewShardStrategy(l.Config.ShardStrategy, gb.SessionStartLimit.MaxConcurrency, guilds)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := strategy.(WeightedStrategy); ok && len(guilds) == 0 && (l.Config.AdoptClusters || l.Config.HA.Enabled) {
+		if state := l.LoadState(); state != nil {
+			if layout := savedLayout(state, gb.Shards, l.Config.PerCluster); layout != nil {
+				return GetClusterMap(l.Config.Names, gb.Shards, l.Config.PerCluster, layout), nil
+			}
+		}
+	}
+
+	return GetClusterMap(l.Config.Names, gb.Shards, l.Config.PerCluster, strategy), nil
+}
+
+// Returns the guild count of every shard with a cached shard health reporting one
+func (l *InstanceList) shardGuilds() map[uint64]uint64 {
+	guilds := map[uint64]uint64{}
+
+	for _, i := range l.List() {
+		for _, h := range i.Health() {
+			if h.Guilds > 0 {
+				guilds[h.ShardID] = h.Guilds
+			}
+		}
+	}
+
+	return guilds
+}
+
+// A fixed cluster layout
+type layoutStrategy [][]uint64
+
+func (s layoutStrategy) Distribute(shards uint64, perCluster uint64) [][]uint64 {
+	return s
+}
+
+// Returns the cluster layout of a saved state, or nil if it does not run every one of shards exactly once in as many
+// clusters (numbered from 0) as the other strategies would use
+func savedLayout(state *State, shards uint64, perCluster uint64) layoutStrategy {
+	if perCluster == 0 {
+		perCluster = 1
+	}
+
+	if state.ShardCount != shards || uint64(len(state.Instances)) != clusterCount(shards, perCluster) {
+		return nil
+	}
+
+	layout := make(layoutStrategy, len(state.Instances))
+	seen := map[uint64]bool{}
+
+	for _, is := range state.Instances {
+		if is.ClusterID < 0 || is.ClusterID >= len(layout) || layout[is.ClusterID] != nil || len(is.Shards) == 0 || uint64(len(is.Shards)) > perCluster {
+			return nil
+		}
+
+		for _, shard := range is.Shards {
+			if shard >= shards || seen[shard] {
+				return nil
+			}
+
+			seen[shard] = true
+		}
+
+		layout[is.ClusterID] = is.Shards
+	}
+
+	if uint64(len(seen)) != shards {
+		return nil
+	}
+
+	return layout
+}
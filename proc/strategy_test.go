@@ -0,0 +1,193 @@
+package proc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetClusterMap(t *testing.T) {
+	guilds := map[uint64]uint64{}
+	for shard := uint64(0); shard < 24; shard++ {
+		guilds[shard] = 1000 + shard*shard*100
+	}
+
+	tests := []struct {
+		name       string
+		shards     uint64
+		perCluster uint64
+		strategy   ShardStrategy
+		limit      uint64 // Most shards a cluster may get
+		clusters   int
+	}{
+		{"contiguous", 20, 6, ContiguousStrategy{}, 6, 4},
+		{"contiguous exact", 24, 6, ContiguousStrategy{}, 6, 4},
+		{"contiguous per cluster 0", 3, 0, ContiguousStrategy{}, 1, 3},
+		{"round robin", 20, 6, RoundRobinStrategy{}, 6, 4},
+		{"round robin single cluster", 5, 10, RoundRobinStrategy{}, 10, 1},
+		{"bucket aligned", 32, 10, BucketAlignedStrategy{MaxConcurrency: 4}, 8, 4},
+		{"bucket aligned uneven", 30, 8, BucketAlignedStrategy{MaxConcurrency: 4}, 8, 4},
+		{"bucket aligned rounded up", 32, 3, BucketAlignedStrategy{MaxConcurrency: 16}, 16, 2},
+		{"bucket aligned no max concurrency", 6, 4, BucketAlignedStrategy{}, 4, 2},
+		{"weighted", 24, 5, WeightedStrategy{Guilds: guilds}, 5, 5},
+		{"weighted partial guilds", 24, 6, WeightedStrategy{Guilds: map[uint64]uint64{0: 100000, 1: 5}}, 6, 4},
+		{"weighted without guilds", 20, 6, WeightedStrategy{}, 6, 4},
+		{"no shards", 0, 6, ContiguousStrategy{}, 6, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusterMap := GetClusterMap([]string{"a", "b"}, tt.shards, tt.perCluster, tt.strategy)
+
+			if len(clusterMap) != tt.clusters {
+				t.Fatalf("got %d clusters, want %d", len(clusterMap), tt.clusters)
+			}
+
+			seen := map[uint64]int{}
+
+			for id, cm := range clusterMap {
+				if cm.ID != id {
+					t.Errorf("cluster %d has ID %d", id, cm.ID)
+				}
+
+				if id < 2 && cm.Name != []string{"a", "b"}[id] {
+					t.Errorf("cluster %d is named %q", id, cm.Name)
+				}
+
+				if len(cm.Shards) == 0 || uint64(len(cm.Shards)) > tt.limit {
+					t.Errorf("cluster %d has %d shards, want 1 to %d", id, len(cm.Shards), tt.limit)
+				}
+
+				for _, shard := range cm.Shards {
+					seen[shard]++
+				}
+			}
+
+			for shard := uint64(0); shard < tt.shards; shard++ {
+				if seen[shard] != 1 {
+					t.Errorf("shard %d is in %d clusters", shard, seen[shard])
+				}
+			}
+
+			if uint64(len(seen)) != tt.shards {
+				t.Errorf("got %d distinct shards, want %d", len(seen), tt.shards)
+			}
+		})
+	}
+}
+
+func TestBucketAlignedStrategyBuckets(t *testing.T) {
+	clusters := BucketAlignedStrategy{MaxConcurrency: 4}.Distribute(32, 10)
+
+	for id, shards := range clusters {
+		buckets := map[uint64]bool{}
+
+		for _, shard := range shards {
+			buckets[shard%4] = true
+		}
+
+		if len(buckets) != 4 {
+			t.Errorf("cluster %d runs %d identify buckets, want 4", id, len(buckets))
+		}
+	}
+}
+
+func TestWeightedStrategyBalance(t *testing.T) {
+	tests := []struct {
+		name       string
+		guilds     map[uint64]uint64
+		shards     uint64
+		perCluster uint64
+	}{
+		{"growing", map[uint64]uint64{0: 100, 1: 200, 2: 300, 3: 400, 4: 500, 5: 600, 6: 700, 7: 800}, 8, 2},
+		{"one large shard", map[uint64]uint64{0: 5000, 1: 100, 2: 100, 3: 100, 4: 100, 5: 100, 6: 100, 7: 100, 8: 100}, 9, 3},
+		{"old shards larger", map[uint64]uint64{0: 900, 1: 800, 2: 700, 3: 600, 4: 100, 5: 100, 6: 100, 7: 100}, 8, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusters := WeightedStrategy{Guilds: tt.guilds}.Distribute(tt.shards, tt.perCluster)
+
+			var heaviest uint64
+			for _, g := range tt.guilds {
+				if g > heaviest {
+					heaviest = g
+				}
+			}
+
+			minLoad, maxLoad := ^uint64(0), uint64(0)
+
+			for _, shards := range clusters {
+				var load uint64
+				for _, shard := range shards {
+					load += tt.guilds[shard]
+				}
+
+				if load < minLoad {
+					minLoad = load
+				}
+
+				if load > maxLoad {
+					maxLoad = load
+				}
+			}
+
+			// Greedy balancing never leaves clusters further apart than the heaviest shard
+			if maxLoad-minLoad > heaviest {
+				t.Errorf("cluster loads range from %d to %d, more than the heaviest shard (%d) apart: %v", minLoad, maxLoad, heaviest, clusters)
+			}
+
+			// The guild counts are chosen so that contiguous blocks are unbalanced
+			contiguous := ContiguousStrategy{}.Distribute(tt.shards, tt.perCluster)
+
+			if reflect.DeepEqual(clusters, contiguous) {
+				t.Errorf("weighted strategy distributed shards like the contiguous strategy: %v", clusters)
+			}
+		})
+	}
+}
+
+func TestSavedLayout(t *testing.T) {
+	state := func(shardCount uint64, layout ...[]uint64) *State {
+		s := &State{ShardCount: shardCount}
+
+		for id, shards := range layout {
+			s.Instances = append(s.Instances, InstanceState{ClusterID: id, Shards: shards})
+		}
+
+		return s
+	}
+
+	tests := []struct {
+		name       string
+		state      *State
+		shards     uint64
+		perCluster uint64
+		want       layoutStrategy
+	}{
+		{"valid", state(4, []uint64{0, 3}, []uint64{1, 2}), 4, 2, layoutStrategy{{0, 3}, {1, 2}}},
+		{"fewer shards per cluster", state(4, []uint64{0, 3, 2}, []uint64{1}), 4, 3, layoutStrategy{{0, 3, 2}, {1}}},
+		{"shard count changed", state(4, []uint64{0, 3}, []uint64{1, 2}), 6, 2, nil},
+		{"cluster count changed", state(4, []uint64{0}, []uint64{1}, []uint64{2}, []uint64{3}), 4, 2, nil},
+		{"too many shards per cluster", state(4, []uint64{0, 1, 2}, []uint64{3}), 4, 2, nil},
+		{"duplicate shard", state(4, []uint64{0, 1}, []uint64{1, 2}), 4, 2, nil},
+		{"unknown shard", state(4, []uint64{0, 1}, []uint64{2, 4}), 4, 2, nil},
+		{"empty cluster", state(4, []uint64{0, 1, 2, 3}, nil), 4, 4, nil},
+		{"no clusters", state(4), 4, 2, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := savedLayout(tt.state, tt.shards, tt.perCluster); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Duplicate cluster IDs
+	dup := state(4, []uint64{0, 1}, []uint64{2, 3})
+	dup.Instances[1].ClusterID = 0
+
+	if got := savedLayout(dup, 4, 2); got != nil {
+		t.Errorf("got %v for duplicate cluster IDs, want nil", got)
+	}
+}
@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A parsed cron expression
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // Bitsets of allowed values
+
+	domStar, dowStar bool // Whether day of month/day of week are unrestricted
+}
+
+// Shorthands for common cron expressions
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parses a standard 5 field cron expression (minute, hour, day of month, month, day of week)
+//
+// Fields support “*“, ranges (“1-5“), steps (“*/15“, “1-30/5“) and lists (“1,15,30“)
+func ParseCron(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields, got %d", len(fields))
+	}
+
+	var s Schedule
+	var err error
+
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+
+	// Both 0 and 7 are sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domStar = cronStar(fields[2])
+	s.dowStar = cronStar(fields[4])
+
+	return &s, nil
+}
+
+// Returns true if a field allows every value, a step such as “*/2“ restricts the field
+func cronStar(field string) bool {
+	return field == "*" || field == "*/1"
+}
+
+func parseCronField(field string, first, last int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			var err error
+			rng = part[:idx]
+			step, err = strconv.Atoi(part[idx+1:])
+
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		lo, hi := first, last
+
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+
+			var err error
+			lo, err = strconv.Atoi(bounds[0])
+
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+
+			hi = lo
+
+			if len(bounds) == 2 {
+				hi, err = strconv.Atoi(bounds[1])
+
+				if err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if step > 1 {
+				hi = last // 5/15 means every 15 starting at 5
+			}
+		}
+
+		if lo < first || hi > last || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, first, last)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	// If both day fields are restricted, either of them matching is enough (like cron)
+	if !s.domStar && !s.dowStar {
+		return domMatch || dowMatch
+	}
+
+	return domMatch && dowMatch
+}
+
+// Returns the first time after t matching the schedule, or the zero time if there is none in the next 5 years
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location()).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// 2026-01-01 is a thursday
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		// Steps
+		{"minute step", "*/15 * * * *", at(1, 1, 0, 0), at(1, 1, 0, 15)},
+		{"minute step from value", "5/20 * * * *", at(1, 1, 0, 5), at(1, 1, 0, 25)},
+		{"range step", "0 9-17/4 * * *", at(1, 1, 9, 0), at(1, 1, 13, 0)},
+		{"range step wraps to next day", "0 9-17/4 * * *", at(1, 1, 17, 0), at(1, 2, 9, 0)},
+
+		// Ranges and lists
+		{"range", "30 1-3 * * *", at(1, 1, 2, 30), at(1, 1, 3, 30)},
+		{"after range", "30 1-3 * * *", at(1, 1, 3, 30), at(1, 2, 1, 30)},
+		{"list", "0 0 1,15 * *", at(1, 1, 0, 0), at(1, 15, 0, 0)},
+		{"month", "0 0 1 6 *", at(1, 1, 0, 0), at(6, 1, 0, 0)},
+		{"sunday as 7", "0 0 * * 7", at(1, 1, 0, 0), at(1, 4, 0, 0)},
+
+		// Day of month and day of week
+		{"day of month only", "0 0 13 * *", at(1, 1, 0, 0), at(1, 13, 0, 0)},
+		{"day of week only", "0 0 * * 1", at(1, 1, 0, 0), at(1, 5, 0, 0)},
+		{"either day matches (day of week first)", "0 0 13 * 5", at(1, 1, 0, 0), at(1, 2, 0, 0)},
+		{"either day matches (day of month first)", "0 0 13 * 5", at(1, 9, 0, 0), at(1, 13, 0, 0)},
+		{"day of month step is restricted", "0 0 */2 * 1", at(1, 1, 0, 0), at(1, 3, 0, 0)},
+		{"day of week step is restricted", "0 0 1 * */2", at(1, 1, 0, 0), at(1, 3, 0, 0)},
+		{"step of 1 is unrestricted", "0 0 13 * */1", at(1, 1, 0, 0), at(1, 13, 0, 0)},
+		{"impossible date", "0 0 31 2 *", at(1, 1, 0, 0), time.Time{}},
+
+		// Shorthands
+		{"@yearly", "@yearly", at(1, 1, 0, 0), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@annually", "@annually", at(3, 1, 0, 0), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", "@monthly", at(1, 1, 0, 0), at(2, 1, 0, 0)},
+		{"@weekly", "@weekly", at(1, 1, 0, 0), at(1, 4, 0, 0)},
+		{"@daily", "@daily", at(1, 1, 10, 30), at(1, 2, 0, 0)},
+		{"@midnight", "@midnight", at(1, 1, 23, 59), at(1, 2, 0, 0)},
+		{"@hourly", "@hourly", at(1, 1, 10, 30), at(1, 1, 11, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseCron(tt.expr)
+
+			if err != nil {
+				t.Fatalf("could not parse %q: %s", tt.expr, err)
+			}
+
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+		"@often",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("expected %q to be invalid", expr)
+		}
+	}
+}
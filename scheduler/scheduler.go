@@ -0,0 +1,270 @@
+// Scheduler for running operations (rolling restarts, cluster restarts, reshards) on cron expressions or at specific times
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cheesycod/mewld/config"
+	"github.com/cheesycod/mewld/proc"
+	"github.com/cheesycod/mewld/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Operations which can be scheduled
+const (
+	OpRollingRestart = "rollingrestart"
+	OpRestart        = "restart"
+	OpReshard        = "reshard"
+)
+
+// A scheduled operation, either recurring (Cron) or one-off (At)
+type Job struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Operation string     `json:"operation"`
+	ClusterID *int       `json:"cluster_id,omitempty"` // Cluster to restart, only for restart
+	Cron      string     `json:"cron,omitempty"`
+	At        *time.Time `json:"at,omitempty"`
+	NextRun   time.Time  `json:"next_run"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+
+	schedule *Schedule
+	cancel   chan struct{}
+}
+
+type Scheduler struct {
+	InstanceList *proc.InstanceList
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func New(il *proc.InstanceList) *Scheduler {
+	return &Scheduler{
+		InstanceList: il,
+		jobs:         map[string]*Job{},
+	}
+}
+
+// Creates a scheduler with the jobs from “schedules“ in the config
+func NewFromConfig(il *proc.InstanceList, ops []config.ScheduledOperation) (*Scheduler, error) {
+	s := New(il)
+
+	for _, op := range ops {
+		_, err := s.Add(Job{
+			Name:      op.Name,
+			Operation: op.Operation,
+			ClusterID: op.ClusterID,
+			Cron:      op.Cron,
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", op.Name, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Adds a job to the scheduler, returning the added job
+func (s *Scheduler) Add(job Job) (*Job, error) {
+	switch job.Operation {
+	case OpRollingRestart, OpReshard:
+	case OpRestart:
+		if job.ClusterID == nil {
+			return nil, errors.New("restart requires a cluster_id")
+		}
+
+		if s.InstanceList.InstanceByID(*job.ClusterID) == nil {
+			return nil, fmt.Errorf("no cluster with id %d", *job.ClusterID)
+		}
+	default:
+		return nil, fmt.Errorf("unknown operation %q", job.Operation)
+	}
+
+	if (job.Cron == "") == (job.At == nil) {
+		return nil, errors.New("exactly one of cron or at must be set")
+	}
+
+	if job.Cron != "" {
+		sched, err := ParseCron(job.Cron)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression: %w", err)
+		}
+
+		job.schedule = sched
+		job.NextRun = sched.Next(time.Now())
+
+		if job.NextRun.IsZero() {
+			return nil, errors.New("cron expression never matches")
+		}
+	} else {
+		if job.At.Before(time.Now()) {
+			return nil, errors.New("at must be in the future")
+		}
+
+		job.NextRun = *job.At
+	}
+
+	job.ID = utils.RandomString(12)
+	job.LastRun = nil
+	job.cancel = make(chan struct{})
+
+	if job.Name == "" {
+		job.Name = job.Operation
+	}
+
+	j := &job
+
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+
+	log.Info("Scheduled ", j.Operation, " (", j.Name, ") with id ", j.ID, ", next run at ", j.NextRun)
+
+	go s.loop(j)
+
+	return j, nil
+}
+
+// Cancels a job, returning false if there is no job with the given ID
+func (s *Scheduler) Cancel(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+
+	if !ok {
+		return false
+	}
+
+	close(j.cancel)
+	delete(s.jobs, id)
+
+	log.Info("Cancelled scheduled job ", id, " (", j.Name, ")")
+
+	return true
+}
+
+// Returns a copy of all jobs, sorted by their next run
+func (s *Scheduler) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, *j)
+	}
+
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].NextRun.Before(jobs[b].NextRun)
+	})
+
+	return jobs
+}
+
+func (s *Scheduler) loop(j *Job) {
+	for {
+		s.mu.Lock()
+		next := j.NextRun
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-j.cancel:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+
+		s.mu.Lock()
+		j.LastRun = &now
+		if j.schedule != nil {
+			j.NextRun = j.schedule.Next(now)
+		}
+		s.mu.Unlock()
+
+		s.run(j)
+
+		if j.schedule == nil || j.NextRun.IsZero() {
+			// One-off jobs are removed once they have run
+			s.mu.Lock()
+			delete(s.jobs, j.ID)
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (s *Scheduler) run(j *Job) {
+	il := s.InstanceList
+
+	if !il.IsLeader() {
+		log.Info("Not the leader, skipping scheduled job ", j.ID, " (", j.Name, ")")
+		return
+	}
+
+	log.Info("Running scheduled job ", j.ID, " (", j.Name, "): ", j.Operation)
+
+	payload := map[string]any{
+		"event":     "scheduled_operation",
+		"job":       j.ID,
+		"name":      j.Name,
+		"operation": j.Operation,
+	}
+
+	if j.ClusterID != nil {
+		payload["id"] = *j.ClusterID
+	}
+
+	il.ActionLog(payload)
+
+	var err error
+	switch j.Operation {
+	case OpRollingRestart:
+		err = il.RollingRestart()
+	case OpRestart:
+		i := il.InstanceByID(*j.ClusterID)
+
+		if i == nil {
+			err = fmt.Errorf("no cluster with id %d", *j.ClusterID)
+			break
+		}
+
+		err = il.Restart(i, "Scheduler")
+	case OpReshard:
+		il.ActionLog(map[string]any{
+			"event":     "reshard_begin",
+			"subsystem": "scheduler",
+		})
+
+		err = il.Reshard()
+
+		if err == nil {
+			il.ActionLog(map[string]any{
+				"event":     "reshard_success",
+				"subsystem": "scheduler",
+			})
+		}
+	}
+
+	if err != nil {
+		log.Error("Scheduled job ", j.ID, " (", j.Name, ") failed: ", err)
+		il.ActionLog(map[string]any{
+			"event":     "scheduled_operation_failed",
+			"job":       j.ID,
+			"name":      j.Name,
+			"operation": j.Operation,
+			"error":     err.Error(),
+		})
+	}
+}
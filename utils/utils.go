@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -130,7 +131,19 @@ const (
 	letterIdxMax  = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
 )
 
-var src = rand.NewSource(time.Now().UnixNano())
+// A rand.Source which is safe for concurrent use, RandomString is called from the goroutines of every cluster
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+var src = &lockedSource{src: rand.NewSource(time.Now().UnixNano())}
 
 func RandomString(n int) string {
 	b := make([]byte, n)
@@ -0,0 +1,60 @@
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/cheesycod/mewld/proc"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Creates the server agents (“mewld agent“) register with and send heartbeats to
+//
+// Requests must set the “Authorization“ header to “agents.token“
+func StartAgentServer(il *proc.InstanceList, addr string) http.Server {
+	r := chi.NewMux()
+
+	r.Use(middleware.Recoverer)
+
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get(proc.AgentTokenHeader)
+
+			if subtle.ConstantTimeCompare([]byte(token), []byte(il.Config.Agents.Token)) != 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("invalid token"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	r.Post("/agents/register", func(w http.ResponseWriter, r *http.Request) {
+		var agent proc.Agent
+
+		err := json.NewDecoder(r.Body).Decode(&agent)
+
+		if err != nil || agent.Name == "" || agent.URL == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid agent"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(il.RegisterAgent(agent))
+	})
+
+	r.Get("/agents", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(il.Agents())
+	})
+
+	return http.Server{
+		Addr:    addr,
+		Handler: r,
+	}
+}
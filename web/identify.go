@@ -0,0 +1,55 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cheesycod/mewld/proc"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Creates the identify queue server
+//
+// This is compatible with twilight's gateway-queue, “GET /?shard=N“ (or “GET /identify?shard=N“) blocks until shard N is allowed to identify
+func StartIdentifyQueue(il *proc.InstanceList, addr string) http.Server {
+	r := chi.NewMux()
+
+	r.Use(middleware.Recoverer)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		shard, err := strconv.ParseUint(r.URL.Query().Get("shard"), 10, 64)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid shard"))
+			return
+		}
+
+		err = il.WaitForIdentify(r.Context(), shard)
+
+		if err != nil {
+			if r.Context().Err() == nil {
+				log.Error("Identify queue error for shard ", shard, ": ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+			}
+			return
+		}
+
+		log.Debug("Shard ", shard, " allowed to identify")
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	r.Get("/", handler)
+	r.Get("/identify", handler)
+
+	return http.Server{
+		Addr:    addr,
+		Handler: r,
+	}
+}
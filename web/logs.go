@@ -0,0 +1,58 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cheesycod/mewld/proc"
+)
+
+// Streams the last n lines and then all new lines of a log buffer using Server-Sent Events
+func streamLogs(w http.ResponseWriter, r *http.Request, buf *proc.LogBuffer, n int) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("{\"error\": \"Streaming is not supported\"}"))
+		return
+	}
+
+	// Subscribe before sending the backlog so no lines are missed in between
+	lines, unsubscribe := buf.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, line := range buf.Last(n) {
+		writeEvent(w, line)
+	}
+
+	flusher.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			w.Write([]byte(": keepalive\n\n"))
+			flusher.Flush()
+		case line := <-lines:
+			writeEvent(w, line)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, line string) {
+	// A single line may still contain carriage returns which would split the event
+	fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(line, "\r", ""))
+}
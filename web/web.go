@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/cheesycod/mewld/proc"
+	"github.com/cheesycod/mewld/scheduler"
 	"github.com/cheesycod/mewld/utils"
 
 	log "github.com/sirupsen/logrus"
@@ -20,7 +21,15 @@ import (
 )
 
 type WebData struct {
+	Name         string // Name of the bot, its routes are also served under /bots/{name}
 	InstanceList *proc.InstanceList
+	Scheduler    *scheduler.Scheduler // Optional, scheduling endpoints are disabled if nil
+	Bots         []WebData            // Other bots run by this mewld process, served under /bots/{name}. Logins are handled by this bot
+}
+
+// Returns this bot and the other bots run by this mewld process
+func (webData WebData) bots() []WebData {
+	return append([]WebData{webData}, webData.Bots...)
 }
 
 func checkAuth(webData WebData, r *http.Request) *loginDat {
@@ -95,6 +104,10 @@ type loginDat struct {
 }
 
 func StartWebserver(webData WebData) http.Server {
+	if webData.Name == "" {
+		webData.Name = "default"
+	}
+
 	// Create webserver using gin
 	r := chi.NewMux()
 
@@ -113,148 +126,28 @@ func StartWebserver(webData WebData) http.Server {
 		},
 	))
 
-	r.Get("/instance-list", loginRoute(
-		webData,
-		func(w http.ResponseWriter, r *http.Request, sessData *loginDat) {
-			err := json.NewEncoder(w).Encode(webData.InstanceList)
-
-			if err != nil {
-				w.Write([]byte(err.Error()))
-				w.WriteHeader(http.StatusInternalServerError)
-			}
-		},
-	))
+	botRoutes(r, webData, webData)
 
-	r.Get("/action-logs", loginRoute(
+	r.Get("/bots", loginRoute(
 		webData,
 		func(w http.ResponseWriter, r *http.Request, sessData *loginDat) {
-			payload, err := webData.InstanceList.IPC.GetKey_Array("actlogs")
-
-			if err != nil {
-				w.Write([]byte(err.Error()))
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-
-			var payloadFinal []map[string]any
-
-			for i, p := range payload {
-				var pm map[string]any
-
-				err := json.Unmarshal([]byte(p), &pm)
-
-				if err != nil {
-					w.Write([]byte(fmt.Sprintf("Could not unmarshal payload %d: %s", i, err.Error())))
-					return
-				}
-
-				payloadFinal = append(payloadFinal, pm)
-			}
-
-			err = json.NewEncoder(w).Encode(payloadFinal)
-
-			if err != nil {
-				w.Write([]byte(err.Error()))
-				w.WriteHeader(http.StatusInternalServerError)
-			}
-		},
-	))
-
-	r.Post("/redis/pub", loginRoute(
-		webData,
-		func(w http.ResponseWriter, r *http.Request, sessData *loginDat) {
-			payload, err := io.ReadAll(r.Body)
-
-			if err != nil {
-				log.Error(err)
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte("Error reading body:" + err.Error()))
-				return
-			}
-
-			webData.InstanceList.IPC.Write(payload)
-		},
-	))
-
-	r.Get("/cluster-health", loginRoute(
-		webData,
-		func(w http.ResponseWriter, r *http.Request, sess *loginDat) {
-			var cid = r.URL.Query().Get("cid")
-
-			if cid == "" {
-				cid = "0"
-			}
-
-			cInt, err := strconv.Atoi(cid)
-
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte("{\"error\": \"Invalid cid, could not parse as int\"}"))
-				return
-			}
-
-			instance := webData.InstanceList.InstanceByID(cInt)
-
-			if instance == nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte("{\"error\": \"Invalid cid, no such instance\"}"))
-				return
-			}
-
-			if instance.ClusterHealth == nil {
-				if !instance.LaunchedFully {
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusBadRequest)
-					w.Write([]byte("{\"error\": \"Instance not fully launched\"}"))
-					return
-				}
-				ch, err := webData.InstanceList.ScanShards(instance)
-
-				if err != nil {
-					var m = map[string]string{
-						"error": "Error scanning shards: " + err.Error(),
-					}
-
-					bytes, err := json.Marshal(m)
-
-					if err != nil {
-						w.Header().Set("Content-Type", "application/json")
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte("{\"error\": \"Error scanning shards: unable to unmarshal payload\"}"))
-						return
-					}
-
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusBadRequest)
-					w.Write(bytes)
-					return
-				}
-
-				instance.ClusterHealth = ch
-			}
-
-			data := map[string]any{
-				"locked": instance.Locked(),
-				"health": instance.ClusterHealth,
-			}
-
-			bytes, err := json.Marshal(data)
-
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte("{\"error\": \"Error marshalling data: unable to unmarshal payload\"}"))
-				return
+			names := []string{}
+			for _, b := range webData.bots() {
+				names = append(names, b.Name)
 			}
 
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(bytes)
+			json.NewEncoder(w).Encode(names)
 		},
 	))
 
+	for _, b := range webData.bots() {
+		b := b
+		r.Route("/bots/"+b.Name, func(r chi.Router) {
+			botRoutes(r, webData, b)
+		})
+	}
+
 	r.Get("/login", func(w http.ResponseWriter, r *http.Request) {
 		// Redirect via discord oauth2
 		url := "https://discord.com/api/oauth2/authorize?client_id=" + webData.InstanceList.Config.Oauth.ClientID + "&redirect_uri=" + webData.InstanceList.Config.Oauth.RedirectURL + "/confirm&response_type=code&scope=identify%20guilds%20applications.commands.permissions.update&state=" + r.URL.Query().Get("api")
@@ -451,3 +344,402 @@ func StartWebserver(webData WebData) http.Server {
 		Handler: r,
 	}
 }
+
+// Registers the routes of a bot, sessions are checked using auth (the bot handling logins)
+func botRoutes(r chi.Router, auth WebData, webData WebData) {
+	r.Get("/instance-list", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sessData *loginDat) {
+			err := json.NewEncoder(w).Encode(webData.InstanceList.Snapshot())
+
+			if err != nil {
+				w.Write([]byte(err.Error()))
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		},
+	))
+
+	r.Get("/session-budget", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sessData *loginDat) {
+			ok, _ := webData.InstanceList.CanSpendSessions(0)
+			snap := webData.InstanceList.Snapshot()
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{
+				"budget":                          snap.SessionBudget,
+				"minimum_safe_sessions_remaining": webData.InstanceList.Config.MinimumSafeSessionsRemaining,
+				"restarts_allowed":                ok,
+			})
+		},
+	))
+
+	r.Get("/rolling-restart", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sessData *loginDat) {
+			snap := webData.InstanceList.Snapshot()
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{
+				"in_progress": snap.RollRestarting,
+				"progress":    snap.RollingRestartProgress,
+			})
+		},
+	))
+
+	r.Post("/rolling-restart/resume", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sess *loginDat) {
+			snap := webData.InstanceList.Snapshot()
+
+			if snap.RollingRestartProgress == nil || !snap.RollingRestartProgress.Paused || snap.RollRestarting {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte("{\"error\": \"No paused rolling restart\"}"))
+				return
+			}
+
+			go webData.InstanceList.ActionLog(map[string]any{
+				"event": "rolling_restart_resume_requested",
+				"user":  sess.ID,
+			})
+
+			go func() {
+				if err := webData.InstanceList.ResumeRollingRestart(); err != nil {
+					log.Error("Could not resume rolling restart: ", err)
+				}
+			}()
+
+			w.WriteHeader(http.StatusAccepted)
+		},
+	))
+
+	r.Get("/reshard/plan", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sessData *loginDat) {
+			plan, err := webData.InstanceList.PlanReshard()
+
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(plan)
+		},
+	))
+
+	r.Get("/agents", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sessData *loginDat) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(webData.InstanceList.Agents())
+		},
+	))
+
+	r.Get("/action-logs", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sessData *loginDat) {
+			payload, err := webData.InstanceList.IPC.GetKey_Array("actlogs")
+
+			if err != nil {
+				w.Write([]byte(err.Error()))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			var payloadFinal []map[string]any
+
+			for i, p := range payload {
+				var pm map[string]any
+
+				err := json.Unmarshal([]byte(p), &pm)
+
+				if err != nil {
+					w.Write([]byte(fmt.Sprintf("Could not unmarshal payload %d: %s", i, err.Error())))
+					return
+				}
+
+				payloadFinal = append(payloadFinal, pm)
+			}
+
+			err = json.NewEncoder(w).Encode(payloadFinal)
+
+			if err != nil {
+				w.Write([]byte(err.Error()))
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		},
+	))
+
+	r.Post("/redis/pub", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sessData *loginDat) {
+			payload, err := io.ReadAll(r.Body)
+
+			if err != nil {
+				log.Error(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Error reading body:" + err.Error()))
+				return
+			}
+
+			webData.InstanceList.IPC.Write(payload)
+		},
+	))
+
+	r.Get("/cluster-health", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sess *loginDat) {
+			var cid = r.URL.Query().Get("cid")
+
+			if cid == "" {
+				cid = "0"
+			}
+
+			cInt, err := strconv.Atoi(cid)
+
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("{\"error\": \"Invalid cid, could not parse as int\"}"))
+				return
+			}
+
+			instance := webData.InstanceList.InstanceByID(cInt)
+
+			if instance == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("{\"error\": \"Invalid cid, no such instance\"}"))
+				return
+			}
+
+			health := instance.Health()
+
+			if health == nil {
+				if !instance.Launched() {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("{\"error\": \"Instance not fully launched\"}"))
+					return
+				}
+				ch, err := webData.InstanceList.ScanShards(instance)
+
+				if err != nil {
+					var m = map[string]string{
+						"error": "Error scanning shards: " + err.Error(),
+					}
+
+					bytes, err := json.Marshal(m)
+
+					if err != nil {
+						w.Header().Set("Content-Type", "application/json")
+						w.WriteHeader(http.StatusInternalServerError)
+						w.Write([]byte("{\"error\": \"Error scanning shards: unable to unmarshal payload\"}"))
+						return
+					}
+
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write(bytes)
+					return
+				}
+
+				instance.SetHealth(ch)
+				health = ch
+			}
+
+			data := map[string]any{
+				"locked": instance.Locked(),
+				"state":  instance.Status(),
+				"health": health,
+			}
+
+			bytes, err := json.Marshal(data)
+
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("{\"error\": \"Error marshalling data: unable to unmarshal payload\"}"))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(bytes)
+		},
+	))
+
+	r.Get("/clusters/{id}/logs", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sess *loginDat) {
+			cInt, err := strconv.Atoi(chi.URLParam(r, "id"))
+
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("{\"error\": \"Invalid cluster id, could not parse as int\"}"))
+				return
+			}
+
+			instance := webData.InstanceList.InstanceByID(cInt)
+
+			if instance == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("{\"error\": \"Invalid cluster id, no such instance\"}"))
+				return
+			}
+
+			var lines = 100
+
+			if l := r.URL.Query().Get("lines"); l != "" {
+				lines, err = strconv.Atoi(l)
+
+				if err != nil {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("{\"error\": \"Invalid lines, could not parse as int\"}"))
+					return
+				}
+			}
+
+			buf := webData.InstanceList.ClusterLogBuffer(instance)
+
+			if r.URL.Query().Get("stream") != "true" && r.Header.Get("Accept") != "text/event-stream" {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]any{
+					"lines": buf.Last(lines),
+				})
+				return
+			}
+
+			streamLogs(w, r, buf, lines)
+		},
+	))
+
+	r.Post("/clusters/{id}/clear_crash_loop", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sess *loginDat) {
+			cInt, err := strconv.Atoi(chi.URLParam(r, "id"))
+
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("{\"error\": \"Invalid cluster id, could not parse as int\"}"))
+				return
+			}
+
+			instance := webData.InstanceList.InstanceByID(cInt)
+
+			if instance == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("{\"error\": \"Invalid cluster id, no such instance\"}"))
+				return
+			}
+
+			go webData.InstanceList.ActionLog(map[string]any{
+				"event": "crash_loop_clear_requested",
+				"id":    cInt,
+				"user":  sess.ID,
+			})
+
+			webData.InstanceList.ClearCrashLoop(instance, "web")
+
+			w.WriteHeader(http.StatusNoContent)
+		},
+	))
+
+	r.Get("/schedules", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sess *loginDat) {
+			if webData.Scheduler == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("{\"error\": \"Scheduler is not enabled\"}"))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(webData.Scheduler.Jobs())
+		},
+	))
+
+	r.Post("/schedules", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sess *loginDat) {
+			if webData.Scheduler == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("{\"error\": \"Scheduler is not enabled\"}"))
+				return
+			}
+
+			var job scheduler.Job
+
+			err := json.NewDecoder(r.Body).Decode(&job)
+
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Could not parse job: " + err.Error(),
+				})
+				return
+			}
+
+			added, err := webData.Scheduler.Add(job)
+
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": err.Error(),
+				})
+				return
+			}
+
+			go webData.InstanceList.ActionLog(map[string]any{
+				"event":     "schedule_created",
+				"job":       added.ID,
+				"operation": added.Operation,
+				"user":      sess.ID,
+			})
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(added)
+		},
+	))
+
+	r.Delete("/schedules/{id}", loginRoute(
+		auth,
+		func(w http.ResponseWriter, r *http.Request, sess *loginDat) {
+			if webData.Scheduler == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("{\"error\": \"Scheduler is not enabled\"}"))
+				return
+			}
+
+			id := chi.URLParam(r, "id")
+
+			if !webData.Scheduler.Cancel(id) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("{\"error\": \"No such job\"}"))
+				return
+			}
+
+			go webData.InstanceList.ActionLog(map[string]any{
+				"event": "schedule_cancelled",
+				"job":   id,
+				"user":  sess.ID,
+			})
+
+			w.WriteHeader(http.StatusNoContent)
+		},
+	))
+}